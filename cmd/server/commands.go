@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+const usage = `usage: grayva [flags] [command]
+
+with no command the server is started, run grayva -h for the flags
+
+settings are read from, each overriding the ones before:
+  1. defaults
+  2. the YAML file given by -config or GDN_CONFIG, gdn.yaml if it exists
+  3. environment variables, including those in .env
+  4. flags
+
+commands:
+  migrate status          print the current and latest schema version
+  migrate up              apply every pending migration
+  migrate down <version>  roll the schema back to version
+  reconcile [-repair]     report objects without metadata and metadata without
+                          objects, only removes them when -repair is given
+  keys list               list api keys
+  keys create <name> <scope>...
+                          create an api key, scopes are photos:write,
+                          photos:delete and admin
+  keys rotate <name>      replace the secret of an api key
+  keys revoke <name>      revoke an api key
+  bans list [-expired]    list bans, expired ones too with -expired
+  bans lift <id>          lift a ban, e.g. one locking out the administrator
+
+the server stops on SIGINT or SIGTERM, letting running requests finish first,
+a second signal stops it at once
+
+exit status:
+  0  stopped cleanly
+  1  failed to start or serve
+  2  invalid usage or configuration
+  3  requests were cut off after the shutdown timeout
+`
+
+// errUsage is returned by commands given invalid arguments, the usage is printed
+var errUsage = errors.New("invalid usage")
+
+// runCommand runs a command and returns the exit status, so run still closes the
+// database and store afterwards
+func runCommand(store *internal.FileStore, command string, args []string) int {
+	var err error
+	switch command {
+	case "migrate":
+		err = migrateCommand(store.Database, args)
+	case "reconcile":
+		err = reconcileCommand(store, args)
+	case "keys":
+		err = keysCommand(store.Database, args)
+	case "bans":
+		err = bansCommand(store.Database, args)
+	default:
+		err = errUsage
+	}
+
+	switch {
+	case errors.Is(err, errUsage):
+		if err != errUsage {
+			log.Println(err)
+		}
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage
+	case err != nil:
+		log.Println(err)
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+func migrateCommand(db *internal.Database, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "status":
+		version, err := db.SchemaVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, latest %d\n", version, internal.LatestSchemaVersion())
+	case "up":
+		if err := db.Migrate(); err != nil {
+			return err
+		}
+		fmt.Printf("schema migrated to version %d\n", internal.LatestSchemaVersion())
+	case "down":
+		if len(args) != 2 {
+			return errUsage
+		}
+
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("%w: invalid version %s", errUsage, args[1])
+		}
+
+		if err := db.MigrateTo(target); err != nil {
+			return err
+		}
+		fmt.Printf("schema migrated to version %d\n", target)
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+func reconcileCommand(store *internal.FileStore, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "remove orphaned objects and metadata instead of only reporting them")
+	bucket := flags.String("bucket", store.Bucket, "bucket holding the original images")
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+
+	if err != nil {
+		return errUsage
+	}
+
+	if err := ensureSchema(store.Database); err != nil {
+		return err
+	}
+
+	report, err := store.Reconcile(store.Context, *bucket, *repair)
+	if report != nil {
+		for _, key := range report.OrphanObjects {
+			fmt.Println("object without metadata:", key)
+		}
+
+		for _, id := range report.OrphanMeta {
+			fmt.Println("metadata without object:", id)
+		}
+
+		for _, key := range report.OrphanDerivatives {
+			fmt.Println("orphaned derivative:", key)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	total := len(report.OrphanObjects) + len(report.OrphanMeta) + len(report.OrphanDerivatives)
+	if *repair {
+		fmt.Printf("%d orphans repaired\n", total)
+	} else {
+		fmt.Printf("%d orphans found, run with -repair to remove them\n", total)
+	}
+
+	return nil
+}
+
+// ensureSchema migrates the database up before commands which need the current schema
+func ensureSchema(db *internal.Database) error {
+	return db.Migrate()
+}
+
+func keysCommand(db *internal.Database, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	if err := ensureSchema(db); err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "list":
+		keys, err := db.ListAPIKeys()
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			state := "active"
+			if !key.RevokedAt.IsZero() {
+				state = "revoked " + key.RevokedAt.Format(time.RFC3339)
+			}
+
+			lastUsed := "never"
+			if !key.LastUsedAt.IsZero() {
+				lastUsed = key.LastUsedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%s\t%s\t%s\tcreated %s\tlast used %s\t%s\n", key.Id, key.Name, strings.Join(key.Scopes, ","),
+				key.CreatedAt.Format(time.RFC3339), lastUsed, state)
+		}
+	case args[0] == "create" && len(args) >= 3:
+		key, secret, err := db.CreateAPIKey(args[1], args[2:])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created %s with scopes %s, store this key now it won't be shown again\n%s\n", key.Name, strings.Join(key.Scopes, ","), secret)
+	case args[0] == "rotate" && len(args) == 2:
+		key, secret, err := db.RotateAPIKey(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rotated %s, store this key now it won't be shown again\n%s\n", key.Name, secret)
+	case args[0] == "revoke" && len(args) == 2:
+		if err := db.RevokeAPIKey(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s\n", args[1])
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+func bansCommand(db *internal.Database, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	if err := ensureSchema(db); err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "list" && (len(args) == 1 || len(args) == 2 && args[1] == "-expired"):
+		bans, err := db.ListBans(len(args) == 2)
+		if err != nil {
+			return err
+		}
+
+		for _, ban := range bans {
+			expires := "never expires"
+			if !ban.ExpiresAt.IsZero() {
+				expires = "expires " + ban.ExpiresAt.Format(time.RFC3339)
+			}
+
+			by := ban.CreatedBy
+			if by == "" {
+				by = ban.Origin
+			}
+			fmt.Printf("%d\t%s\t%s\tby %s\tcreated %s\t%s\n", ban.Id, ban.CIDR, ban.Reason, by,
+				ban.CreatedAt.Format(time.RFC3339), expires)
+		}
+	case args[0] == "lift" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid ban id %s", errUsage, args[1])
+		}
+
+		if err := db.DeleteBan(id); err != nil {
+			return err
+		}
+		fmt.Printf("lifted ban %d\n", id)
+	default:
+		return errUsage
+	}
+
+	return nil
+}
@@ -1,34 +1,160 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/Y2Kwastaken/gdn/config"
 	"github.com/Y2Kwastaken/gdn/httpserv"
 	"github.com/Y2Kwastaken/gdn/internal"
 	_ "modernc.org/sqlite"
 )
 
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitShutdown = 3
+)
+
 func main() {
+	os.Exit(run())
+}
+
+// run starts GDN and returns the exit status instead of exiting, so the database and
+// store are closed by the deferred calls on every path
+func run() int {
+	// env files are optional now everything can also come from the config file
 	err := internal.LoadEnv()
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return exitUsage
+	}
+
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stderr, usage)
+		return exitOK
 	}
 
-	db, err := internal.NewDBConnection("file:gdn_main.sqlite")
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Invalid configuration:\n%v", err)
+		return exitUsage
 	}
-	err = db.SetupTables()
+
+	db, err := internal.NewDBConnection(cfg.Database.DSN)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return exitFailure
 	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Println("Unable to close the database", err)
+		}
+	}()
 
-	store := internal.NewObjectStore("localhost:9000")
+	// the local backend runs GDN on a single box without MinIO
+	var store *internal.FileStore
+	if cfg.Storage.Backend == "local" {
+		store = internal.NewFileStore(internal.NewLocalStore(cfg.Storage.Path))
+	} else {
+		minio := internal.NewMinioStore(cfg.Storage.Minio.Address)
+		minio.Secure = cfg.Storage.Minio.Secure
+		minio.CABundle = cfg.Storage.Minio.CAFile
+		store = internal.NewFileStore(minio)
+	}
 	store.Database = db
-	err = store.Connect("admin", "password")
+	store.Bucket = cfg.Storage.Bucket
+	store.Uploads = cfg.UploadLimits()
+	err = store.Connect(cfg.Storage.Minio.AccessKey, cfg.Storage.Minio.SecretKey)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return exitFailure
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			log.Println("Unable to close the object store", err)
+		}
+	}()
+
+	if len(args) > 0 {
+		return runCommand(store, args[0], args[1:])
+	}
+
+	// the root key is reachable over the network, commands above don't need it
+	err = internal.CheckRootSecret()
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	// never touch a database a newer binary has migrated
+	err = db.CheckSchema()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	err = db.Migrate()
+	if err != nil {
+		log.Println(err)
+		return exitFailure
+	}
+
+	// the derivative worker stops after the server, once uploads have drained
+	worker, stopWorker := context.WithCancel(context.Background())
+	defer stopWorker()
+	store.Context = worker
+
+	// validated with the rest of the config
+	sizes, _ := cfg.DerivativeSizes()
+	store.Derivatives = internal.NewDerivativeWorker(store, store.Bucket, sizes)
+	store.Derivatives.Start(store.Context)
+	// deferred after the closes, so it has stopped before the store and database close
+	defer store.Derivatives.Stop()
+
+	options := httpserv.Options{
+		Address:       cfg.Server.Address,
+		PublicDir:     cfg.Server.PublicDir,
+		Timeouts:      cfg.Timeouts(),
+		TLS:           cfg.TLS(),
+		LimitPolicies: cfg.RateLimit.Policies,
+		Aggregation:   cfg.Aggregation(),
+	}
+
+	// only trust forwarding headers from proxies we run, anyone can send them
+	options.TrustedProxies, _ = cfg.TrustedProxies()
+	options.ClientIPHeader = cfg.RateLimit.ClientIPHeader
+
+	// a database backend shares rate limits between instances on one database
+	if cfg.RateLimit.Backend == "database" {
+		options.Limiter = httpserv.NewDatabaseLimiter(db)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// a second signal falls through to the default handler and kills the process
+		<-ctx.Done()
+		stop()
+	}()
+
+	err = httpserv.SetupHttpServer(ctx, store, options)
+	switch {
+	case errors.Is(err, httpserv.ErrShutdownTimeout):
+		log.Println(err)
+		return exitShutdown
+	case err != nil:
+		log.Println(err)
+		return exitFailure
 	}
 
-	httpserv.SetupHttpServer(store)
+	log.Println("GDN stopped")
+	return exitOK
 }
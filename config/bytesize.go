@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a size in bytes, written as a plain number or with a KB, MB or GB
+// suffix. The suffixes are binary, 1KB being 1024 bytes
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func ParseByteSize(value string) (ByteSize, error) {
+	text := strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	for _, byteUnit := range byteUnits {
+		if number, ok := strings.CutSuffix(text, byteUnit.suffix); ok {
+			text, unit = strings.TrimSpace(number), byteUnit.size
+			break
+		}
+	}
+
+	number, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || number < 0 || number > (1<<62)/unit {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return ByteSize(number * unit), nil
+}
+
+func (size *ByteSize) Set(value string) error {
+	parsed, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+
+	*size = parsed
+	return nil
+}
+
+func (size ByteSize) String() string {
+	for _, byteUnit := range byteUnits {
+		if int64(size) >= byteUnit.size && int64(size)%byteUnit.size == 0 {
+			return strconv.FormatInt(int64(size)/byteUnit.size, 10) + byteUnit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(size), 10) + "B"
+}
+
+func (size *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	return size.Set(node.Value)
+}
@@ -0,0 +1,528 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/httpserv"
+	"github.com/Y2Kwastaken/gdn/internal"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is read when no config file is named, it may be missing
+const DefaultFile = "gdn.yaml"
+
+// Config is every setting of a GDN instance. Settings are layered, each layer
+// overriding the ones before it:
+//
+//  1. the defaults from Default
+//  2. the YAML config file, named by -config or GDN_CONFIG, gdn.yaml otherwise
+//  3. environment variables, including those from .env
+//  4. command line flags
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Uploads   UploadConfig    `yaml:"uploads"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+type ServerConfig struct {
+	Address   string `yaml:"address"`
+	PublicDir string `yaml:"public_dir"`
+	// durations like 30s or 5m
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	TLS               TLSConfig     `yaml:"tls"`
+}
+
+// TLSConfig turns on HTTPS when the certificate and key are set, they are reloaded
+// on SIGHUP and when the files change
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// plain HTTP address redirecting to HTTPS, e.g. :80
+	RedirectAddress string        `yaml:"redirect_address"`
+	HSTSMaxAge      time.Duration `yaml:"hsts_max_age"`
+}
+
+type DatabaseConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+type StorageConfig struct {
+	// minio or local
+	Backend string `yaml:"backend"`
+	// root directory of the local backend
+	Path string `yaml:"path"`
+	// bucket holding the original images
+	Bucket          string      `yaml:"bucket"`
+	DerivativeSizes string      `yaml:"derivative_sizes"`
+	Minio           MinioConfig `yaml:"minio"`
+}
+
+type MinioConfig struct {
+	Address   string `yaml:"address"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// dial MinIO over TLS, trusting the PEM CAFile on top of the system CAs
+	Secure bool   `yaml:"secure"`
+	CAFile string `yaml:"ca_file"`
+}
+
+type UploadConfig struct {
+	MaxImageSize    ByteSize `yaml:"max_image_size"`
+	MaxMetadataSize ByteSize `yaml:"max_metadata_size"`
+}
+
+type RateLimitConfig struct {
+	// memory or database
+	Backend        string   `yaml:"backend"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// the forwarding header the trusted proxies write, x-forwarded-for, forwarded or x-real-ip
+	ClientIPHeader string                 `yaml:"client_ip_header"`
+	IPv4Prefix     int                    `yaml:"ipv4_prefix"`
+	IPv6Prefix     int                    `yaml:"ipv6_prefix"`
+	Policies       []httpserv.LimitPolicy `yaml:"policies"`
+	// a YAML file of policies, replacing Policies
+	PoliciesFile string `yaml:"policies_file"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Address:           ":8080",
+			PublicDir:         "./resources/assets/public",
+			ReadHeaderTimeout: httpserv.DefaultTimeouts.ReadHeader,
+			ReadTimeout:       httpserv.DefaultTimeouts.Read,
+			WriteTimeout:      httpserv.DefaultTimeouts.Write,
+			IdleTimeout:       httpserv.DefaultTimeouts.Idle,
+			ShutdownTimeout:   httpserv.DefaultTimeouts.Shutdown,
+			TLS:               TLSConfig{HSTSMaxAge: 365 * 24 * time.Hour},
+		},
+		Database: DatabaseConfig{DSN: "file:gdn_main.sqlite"},
+		Storage: StorageConfig{
+			Backend: "minio",
+			Path:    "gdn_objects",
+			Bucket:  internal.ImageBucket,
+			Minio:   MinioConfig{Address: "localhost:9000", AccessKey: "admin", SecretKey: "password"},
+		},
+		Uploads: UploadConfig{
+			MaxImageSize:    ByteSize(internal.DefaultUploadLimits.MaxImage),
+			MaxMetadataSize: ByteSize(internal.DefaultUploadLimits.MaxMetadata),
+		},
+		RateLimit: RateLimitConfig{
+			Backend:        "memory",
+			ClientIPHeader: httpserv.DefaultClientIPHeader,
+			IPv4Prefix:     httpserv.DefaultAggregation.IPv4Bits,
+			IPv6Prefix:     httpserv.DefaultAggregation.IPv6Bits,
+		},
+	}
+}
+
+// setting is a value which can be set from the environment and the command line
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(*Config, string) error
+}
+
+var settings = []setting{
+	{"GDN_ADDRESS", "address", "address the HTTP server listens on", func(cfg *Config, value string) error {
+		cfg.Server.Address = value
+		return nil
+	}},
+	{"GDN_PUBLIC_DIR", "public-dir", "directory of the static site", func(cfg *Config, value string) error {
+		cfg.Server.PublicDir = value
+		return nil
+	}},
+	{"GDN_READ_HEADER_TIMEOUT", "read-header-timeout", "time allowed to read request headers", func(cfg *Config, value string) error {
+		return setDuration(&cfg.Server.ReadHeaderTimeout, value)
+	}},
+	{"GDN_READ_TIMEOUT", "read-timeout", "time allowed to read a whole request, uploads included", func(cfg *Config, value string) error {
+		return setDuration(&cfg.Server.ReadTimeout, value)
+	}},
+	{"GDN_WRITE_TIMEOUT", "write-timeout", "time allowed to write a response", func(cfg *Config, value string) error {
+		return setDuration(&cfg.Server.WriteTimeout, value)
+	}},
+	{"GDN_IDLE_TIMEOUT", "idle-timeout", "how long idle keep-alive connections stay open", func(cfg *Config, value string) error {
+		return setDuration(&cfg.Server.IdleTimeout, value)
+	}},
+	{"GDN_SHUTDOWN_TIMEOUT", "shutdown-timeout", "time in-flight requests get to finish on shutdown", func(cfg *Config, value string) error {
+		return setDuration(&cfg.Server.ShutdownTimeout, value)
+	}},
+	{"GDN_TLS_CERT", "tls-cert", "PEM certificate chain, enables HTTPS", func(cfg *Config, value string) error {
+		cfg.Server.TLS.CertFile = value
+		return nil
+	}},
+	{"GDN_TLS_KEY", "tls-key", "PEM private key of the certificate", func(cfg *Config, value string) error {
+		cfg.Server.TLS.KeyFile = value
+		return nil
+	}},
+	{"GDN_TLS_REDIRECT", "tls-redirect", "plain HTTP address redirecting to HTTPS, e.g. :80", func(cfg *Config, value string) error {
+		cfg.Server.TLS.RedirectAddress = value
+		return nil
+	}},
+	{"GDN_HSTS_MAX_AGE", "hsts-max-age", "Strict-Transport-Security max-age over HTTPS, 0 disables it", func(cfg *Config, value string) error {
+		return setDuration(&cfg.Server.TLS.HSTSMaxAge, value)
+	}},
+	{"GDN_DATABASE", "database", "sqlite data source name", func(cfg *Config, value string) error {
+		cfg.Database.DSN = value
+		return nil
+	}},
+	{"STORAGE_BACKEND", "storage", "object storage backend, minio or local", func(cfg *Config, value string) error {
+		cfg.Storage.Backend = value
+		return nil
+	}},
+	{"STORAGE_PATH", "storage-path", "root directory of the local storage backend", func(cfg *Config, value string) error {
+		cfg.Storage.Path = value
+		return nil
+	}},
+	{"GDN_BUCKET", "bucket", "bucket holding the original images", func(cfg *Config, value string) error {
+		cfg.Storage.Bucket = value
+		return nil
+	}},
+	{"DERIVATIVE_SIZES", "derivative-sizes", "derivative sizes as name:max_dimension:format,...", func(cfg *Config, value string) error {
+		cfg.Storage.DerivativeSizes = value
+		return nil
+	}},
+	{"MINIO_ADDRESS", "minio-address", "host:port of MinIO", func(cfg *Config, value string) error {
+		cfg.Storage.Minio.Address = value
+		return nil
+	}},
+	{"MINIO_ACCESS_KEY", "minio-access-key", "MinIO access key", func(cfg *Config, value string) error {
+		cfg.Storage.Minio.AccessKey = value
+		return nil
+	}},
+	{"MINIO_SECRET_KEY", "minio-secret-key", "MinIO secret key", func(cfg *Config, value string) error {
+		cfg.Storage.Minio.SecretKey = value
+		return nil
+	}},
+	{"MINIO_SECURE", "minio-secure", "dial MinIO over TLS, true or false", func(cfg *Config, value string) error {
+		secure, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+
+		cfg.Storage.Minio.Secure = secure
+		return nil
+	}},
+	{"MINIO_CA_FILE", "minio-ca-file", "PEM bundle of CAs trusted for MinIO", func(cfg *Config, value string) error {
+		cfg.Storage.Minio.CAFile = value
+		return nil
+	}},
+	{"MAX_IMAGE_SIZE", "max-image-size", "largest accepted image, e.g. 50MB", func(cfg *Config, value string) error {
+		return cfg.Uploads.MaxImageSize.Set(value)
+	}},
+	{"MAX_METADATA_SIZE", "max-metadata-size", "largest accepted metadata JSON, e.g. 25KB", func(cfg *Config, value string) error {
+		return cfg.Uploads.MaxMetadataSize.Set(value)
+	}},
+	{"RATE_LIMIT_BACKEND", "rate-limit-backend", "rate limit buckets, memory or database", func(cfg *Config, value string) error {
+		cfg.RateLimit.Backend = value
+		return nil
+	}},
+	{"RATE_LIMITS", "rate-limits", "YAML file of rate limit policies", func(cfg *Config, value string) error {
+		cfg.RateLimit.PoliciesFile = value
+		return nil
+	}},
+	{"TRUSTED_PROXIES", "trusted-proxies", "comma separated proxy addresses and CIDR ranges", func(cfg *Config, value string) error {
+		cfg.RateLimit.TrustedProxies = splitList(value)
+		return nil
+	}},
+	{"CLIENT_IP_HEADER", "client-ip-header", "forwarding header the trusted proxies write, x-forwarded-for, forwarded or x-real-ip", func(cfg *Config, value string) error {
+		cfg.RateLimit.ClientIPHeader = value
+		return nil
+	}},
+	{"IPV4_PREFIX", "ipv4-prefix", "IPv4 clients are grouped by this many bits", func(cfg *Config, value string) error {
+		return setBits(&cfg.RateLimit.IPv4Prefix, value)
+	}},
+	{"IPV6_PREFIX", "ipv6-prefix", "IPv6 clients are grouped by this many bits", func(cfg *Config, value string) error {
+		return setBits(&cfg.RateLimit.IPv6Prefix, value)
+	}},
+}
+
+// Load builds the Config from every layer and validates it. args are the command
+// line arguments without the program name, the arguments left after the flags are
+// returned
+func Load(args []string) (*Config, []string, error) {
+	flags := flag.NewFlagSet("grayva", flag.ContinueOnError)
+	path := flags.String("config", "", "YAML config file, defaults to GDN_CONFIG or "+DefaultFile)
+
+	values := make(map[string]*string, len(settings))
+	for _, setting := range settings {
+		values[setting.flag] = flags.String(setting.flag, "", setting.usage+" ("+setting.env+")")
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+
+	file, required := *path, true
+	if file == "" {
+		file = os.Getenv("GDN_CONFIG")
+	}
+	if file == "" {
+		file, required = DefaultFile, false
+	}
+
+	if err := cfg.LoadFile(file); err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+		return nil, nil, err
+	}
+
+	for _, setting := range settings {
+		if value, ok := os.LookupEnv(setting.env); ok && value != "" {
+			if err := setting.set(cfg, value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", setting.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(set *flag.Flag) {
+		for _, setting := range settings {
+			if setting.flag == set.Name && err == nil {
+				if err = setting.set(cfg, *values[set.Name]); err != nil {
+					err = fmt.Errorf("-%s: %w", set.Name, err)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.loadPolicies(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, flags.Args(), nil
+}
+
+// LoadFile layers the YAML file at path over cfg, unknown keys are errors so typos
+// don't go unnoticed
+func (cfg *Config) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadPolicies() error {
+	if cfg.RateLimit.PoliciesFile == "" {
+		return nil
+	}
+
+	policies, err := httpserv.LoadLimitPolicies(cfg.RateLimit.PoliciesFile)
+	if err != nil {
+		return err
+	}
+
+	cfg.RateLimit.Policies = policies
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (cfg *Config) Validate() error {
+	var errs []error
+	invalid := func(name string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+	}
+
+	if cfg.Server.Address == "" {
+		invalid("server.address", "required")
+	}
+
+	timeouts := map[string]time.Duration{
+		"server.read_header_timeout": cfg.Server.ReadHeaderTimeout,
+		"server.read_timeout":        cfg.Server.ReadTimeout,
+		"server.write_timeout":       cfg.Server.WriteTimeout,
+		"server.idle_timeout":        cfg.Server.IdleTimeout,
+		"server.shutdown_timeout":    cfg.Server.ShutdownTimeout,
+	}
+	for _, name := range slices.Sorted(maps.Keys(timeouts)) {
+		if timeouts[name] <= 0 {
+			invalid(name, "must be positive")
+		}
+	}
+
+	tlsConfig := cfg.Server.TLS
+	if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		invalid("server.tls", "cert_file and key_file must be set together")
+	}
+
+	for _, file := range []struct{ name, path string }{{"server.tls.cert_file", tlsConfig.CertFile}, {"server.tls.key_file", tlsConfig.KeyFile}} {
+		if _, err := os.Stat(file.path); file.path != "" && err != nil {
+			invalid(file.name, "%v", err)
+		}
+	}
+
+	if tlsConfig.CertFile == "" && tlsConfig.RedirectAddress != "" {
+		invalid("server.tls.redirect_address", "needs TLS to redirect to")
+	}
+
+	if tlsConfig.RedirectAddress != "" && tlsConfig.RedirectAddress == cfg.Server.Address {
+		invalid("server.tls.redirect_address", "must differ from server.address")
+	}
+
+	if tlsConfig.HSTSMaxAge < 0 {
+		invalid("server.tls.hsts_max_age", "must not be negative")
+	}
+
+	if cfg.Database.DSN == "" {
+		invalid("database.dsn", "required")
+	}
+
+	switch cfg.Storage.Backend {
+	case "minio":
+		if cfg.Storage.Minio.Address == "" {
+			invalid("storage.minio.address", "required for the minio backend")
+		}
+
+		if cfg.Storage.Minio.CAFile != "" && !cfg.Storage.Minio.Secure {
+			invalid("storage.minio.ca_file", "only used with storage.minio.secure")
+		}
+	case "local":
+		if cfg.Storage.Path == "" {
+			invalid("storage.path", "required for the local backend")
+		}
+	default:
+		invalid("storage.backend", "must be minio or local, got %q", cfg.Storage.Backend)
+	}
+
+	if cfg.Storage.Bucket == "" || cfg.Storage.Bucket == internal.DerivativeBucket {
+		invalid("storage.bucket", "must be set and not %q", internal.DerivativeBucket)
+	}
+
+	if _, err := cfg.DerivativeSizes(); err != nil {
+		invalid("storage.derivative_sizes", "%v", err)
+	}
+
+	if cfg.Uploads.MaxImageSize <= 0 {
+		invalid("uploads.max_image_size", "must be positive")
+	}
+
+	if cfg.Uploads.MaxMetadataSize <= 0 {
+		invalid("uploads.max_metadata_size", "must be positive")
+	}
+
+	if cfg.RateLimit.Backend != "memory" && cfg.RateLimit.Backend != "database" {
+		invalid("rate_limit.backend", "must be memory or database, got %q", cfg.RateLimit.Backend)
+	}
+
+	if _, err := cfg.TrustedProxies(); err != nil {
+		invalid("rate_limit.trusted_proxies", "%v", err)
+	}
+
+	if err := httpserv.ValidateClientIPHeader(cfg.RateLimit.ClientIPHeader); err != nil {
+		invalid("rate_limit.client_ip_header", "%v", err)
+	}
+
+	if err := cfg.Aggregation().Validate(); err != nil {
+		invalid("rate_limit", "%v", err)
+	}
+
+	if len(cfg.RateLimit.Policies) > 0 {
+		if err := httpserv.ValidateLimitPolicies(cfg.RateLimit.Policies); err != nil {
+			invalid("rate_limit.policies", "%v", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (cfg *Config) DerivativeSizes() ([]internal.DerivativeSize, error) {
+	if cfg.Storage.DerivativeSizes == "" {
+		return internal.DefaultDerivativeSizes, nil
+	}
+
+	return internal.ParseDerivativeSizes(cfg.Storage.DerivativeSizes)
+}
+
+func (cfg *Config) UploadLimits() internal.UploadLimits {
+	return internal.UploadLimits{MaxImage: int64(cfg.Uploads.MaxImageSize), MaxMetadata: int64(cfg.Uploads.MaxMetadataSize)}
+}
+
+func (cfg *Config) TrustedProxies() ([]netip.Prefix, error) {
+	return httpserv.ParseTrustedProxies(strings.Join(cfg.RateLimit.TrustedProxies, ","))
+}
+
+func (cfg *Config) Timeouts() httpserv.Timeouts {
+	return httpserv.Timeouts{
+		ReadHeader: cfg.Server.ReadHeaderTimeout,
+		Read:       cfg.Server.ReadTimeout,
+		Write:      cfg.Server.WriteTimeout,
+		Idle:       cfg.Server.IdleTimeout,
+		Shutdown:   cfg.Server.ShutdownTimeout,
+	}
+}
+
+func (cfg *Config) TLS() httpserv.TLSOptions {
+	return httpserv.TLSOptions{
+		CertFile:        cfg.Server.TLS.CertFile,
+		KeyFile:         cfg.Server.TLS.KeyFile,
+		RedirectAddress: cfg.Server.TLS.RedirectAddress,
+		HSTSMaxAge:      cfg.Server.TLS.HSTSMaxAge,
+	}
+}
+
+func (cfg *Config) Aggregation() httpserv.Aggregation {
+	return httpserv.Aggregation{IPv4Bits: cfg.RateLimit.IPv4Prefix, IPv6Bits: cfg.RateLimit.IPv6Prefix}
+}
+
+func splitList(value string) []string {
+	var list []string
+	for entry := range strings.SplitSeq(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func setDuration(duration *time.Duration, value string) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected e.g. 30s or 5m", value)
+	}
+
+	*duration = parsed
+	return nil
+}
+
+func setBits(bits *int, value string) error {
+	parsed, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(value), "/"))
+	if err != nil {
+		return fmt.Errorf("invalid prefix length %q", value)
+	}
+
+	*bits = parsed
+	return nil
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gdn.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  address: ":9000"
+  public_dir: ./site
+storage:
+  bucket: photos
+uploads:
+  max_image_size: 10MB
+`)
+
+	t.Setenv("GDN_CONFIG", "")
+	t.Setenv("GDN_PUBLIC_DIR", "./env-site")
+	t.Setenv("GDN_BUCKET", "env-photos")
+
+	cfg, args, err := Load([]string{"-config", path, "-bucket", "flag-photos", "migrate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Address != ":9000" {
+		t.Errorf("address = %q, want the file's :9000", cfg.Server.Address)
+	}
+
+	if cfg.Server.PublicDir != "./env-site" {
+		t.Errorf("public dir = %q, want the environment's ./env-site", cfg.Server.PublicDir)
+	}
+
+	if cfg.Storage.Bucket != "flag-photos" {
+		t.Errorf("bucket = %q, want the flag's flag-photos", cfg.Storage.Bucket)
+	}
+
+	if cfg.Uploads.MaxImageSize != 10<<20 {
+		t.Errorf("max image size = %d, want 10MB", cfg.Uploads.MaxImageSize)
+	}
+
+	if cfg.Database.DSN != Default().Database.DSN {
+		t.Errorf("dsn = %q, want the default", cfg.Database.DSN)
+	}
+
+	if len(args) != 1 || args[0] != "migrate" {
+		t.Errorf("args = %v, want [migrate]", args)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	cfg := Default()
+	if err := cfg.LoadFile(writeConfig(t, "")); err != nil {
+		t.Errorf("empty file: %v", err)
+	}
+
+	err := cfg.LoadFile(writeConfig(t, "server:\n  adress: \":9000\"\n"))
+	if err == nil || !strings.Contains(err.Error(), "adress") {
+		t.Errorf("misspelled key: %v, want an error naming it", err)
+	}
+
+	t.Setenv("GDN_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, _, err := Load(nil); err == nil {
+		t.Error("a missing GDN_CONFIG file should be an error")
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	cfg := Default()
+	if err := cfg.LoadFile("../gdn.example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("defaults invalid: %v", err)
+	}
+
+	cfg := Default()
+	cfg.Storage.Backend = "s3"
+	cfg.Uploads.MaxImageSize = 0
+	cfg.RateLimit.IPv4Prefix = 4
+	cfg.RateLimit.TrustedProxies = []string{"not-a-proxy"}
+	cfg.RateLimit.ClientIPHeader = "x-client-ip"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+
+	for _, field := range []string{"storage.backend", "uploads.max_image_size", "rate_limit:", "rate_limit.trusted_proxies", "rate_limit.client_ip_header"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q doesn't mention %s", err, field)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ByteSize
+		ok    bool
+	}{
+		{"1024", 1024, true},
+		{"25KB", 25 << 10, true},
+		{"50 mb", 50 << 20, true},
+		{"2GB", 2 << 30, true},
+		{"12B", 12, true},
+		{"", 0, false},
+		{"-1KB", 0, false},
+		{"1.5MB", 0, false},
+		{"10TB", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := ParseByteSize(test.value)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v", test.value, got, err)
+		}
+	}
+
+	if got := ByteSize(50 << 20).String(); got != "50MB" {
+		t.Errorf("String() = %q, want 50MB", got)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	cfg := Default()
+	cfg.Server.TLS.CertFile = filepath.Join(t.TempDir(), "missing.pem")
+	cfg.Storage.Minio.CAFile = "ca.pem"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid TLS config accepted")
+	}
+
+	for _, field := range []string{"server.tls:", "server.tls.cert_file", "storage.minio.ca_file"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q doesn't mention %s", err, field)
+		}
+	}
+
+	cfg = Default()
+	cfg.Server.TLS.RedirectAddress = ":80"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.tls.redirect_address") {
+		t.Errorf("redirect without TLS: %v", err)
+	}
+}
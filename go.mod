@@ -2,7 +2,12 @@ module github.com/Y2Kwastaken/gdn
 
 go 1.25.0
 
-require modernc.org/sqlite v1.38.2
+require (
+	github.com/HugoSmits86/nativewebp v1.2.1
+	golang.org/x/image v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.38.2
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -20,7 +25,6 @@ require (
 	golang.org/x/net v0.42.0 // indirect
 	golang.org/x/text v0.27.0 // indirect
 	golang.org/x/tools v0.35.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
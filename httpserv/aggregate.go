@@ -0,0 +1,62 @@
+package httpserv
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Aggregation groups client addresses into prefixes before they are rate limited,
+// locked out or banned. An IPv6 client usually owns a whole /64 and could otherwise
+// rotate through it for a fresh allowance on every request
+type Aggregation struct {
+	IPv4Bits int
+	IPv6Bits int
+}
+
+var DefaultAggregation = Aggregation{IPv4Bits: 32, IPv6Bits: 64}
+
+func (agg Aggregation) Validate() error {
+	if agg.IPv4Bits < 8 || agg.IPv4Bits > 32 {
+		return fmt.Errorf("IPv4 aggregation /%d must be between /8 and /32", agg.IPv4Bits)
+	}
+
+	if agg.IPv6Bits < 16 || agg.IPv6Bits > 128 {
+		return fmt.Errorf("IPv6 aggregation /%d must be between /16 and /128", agg.IPv6Bits)
+	}
+
+	return nil
+}
+
+// Prefix is the group addr belongs to, IPv4-mapped IPv6 addresses count as IPv4
+func (agg Aggregation) Prefix(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap().WithZone("")
+	bits := agg.IPv6Bits
+	if addr.Is4() {
+		bits = agg.IPv4Bits
+	}
+
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+// client returns the group a request comes from, written as a plain address when the
+// group is a single address. Hosts which aren't addresses are used as they are
+func (agg Aggregation) client(remoteAddr string) (string, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return "", err
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host, nil
+	}
+
+	prefix := agg.Prefix(addr)
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String(), nil
+	}
+
+	return prefix.String(), nil
+}
@@ -0,0 +1,112 @@
+package httpserv
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+func TestAggregationClient(t *testing.T) {
+	tests := []struct {
+		agg      Aggregation
+		remote   string
+		expected string
+	}{
+		{DefaultAggregation, "192.0.2.7:1000", "192.0.2.7"},
+		{DefaultAggregation, "[2001:db8:1:2:aaaa::1]:1000", "2001:db8:1:2::/64"},
+		{DefaultAggregation, "[2001:db8:1:2:bbbb::2]:1000", "2001:db8:1:2::/64"},
+		{DefaultAggregation, "[2001:db8:1:3::1]:1000", "2001:db8:1:3::/64"},
+		{DefaultAggregation, "[::ffff:192.0.2.7]:1000", "192.0.2.7"},
+		{DefaultAggregation, "[fe80::1%eth0]:1000", "fe80::/64"},
+		{Aggregation{IPv4Bits: 24, IPv6Bits: 48}, "192.0.2.7:1000", "192.0.2.0/24"},
+		{Aggregation{IPv4Bits: 24, IPv6Bits: 48}, "[::ffff:192.0.2.200]:1000", "192.0.2.0/24"},
+		{Aggregation{IPv4Bits: 24, IPv6Bits: 48}, "[2001:db8:1:2::1]:1000", "2001:db8:1::/48"},
+		{Aggregation{IPv4Bits: 32, IPv6Bits: 128}, "[2001:db8::1]:1000", "2001:db8::1"},
+		{DefaultAggregation, "pipe:0", "pipe"},
+	}
+
+	for _, test := range tests {
+		client, err := test.agg.client(test.remote)
+		if err != nil || client != test.expected {
+			t.Errorf("Expected %s to aggregate to %s under %+v, but got %s %v", test.remote, test.expected, test.agg, client, err)
+		}
+	}
+
+	for _, agg := range []Aggregation{{IPv4Bits: 33, IPv6Bits: 64}, {IPv4Bits: 24, IPv6Bits: 0}, {IPv4Bits: 4, IPv6Bits: 64}} {
+		if err := agg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", agg)
+		}
+	}
+}
+
+func TestAggregatedLimits(t *testing.T) {
+	store := internal.NewTestStore(t)
+
+	options := Options{Aggregation: Aggregation{IPv4Bits: 24}}.withDefaults()
+	router := NewRouter()
+	router.Use(rateLimit(store, options))
+	router.HandleFunc(http.MethodGet, "/api/v1/photos", func(http.ResponseWriter, *http.Request) {})
+	router.HandleFunc(http.MethodGet, "/api/v1/auth", func(http.ResponseWriter, *http.Request) {})
+
+	requestPath := func(path string, remote string) int {
+		rqst := httptest.NewRequest(http.MethodGet, path, nil)
+		rqst.RemoteAddr = remote
+		rspn := httptest.NewRecorder()
+		router.ServeHTTP(rspn, rqst)
+		return rspn.Code
+	}
+
+	request := func(remote string) int {
+		return requestPath("/api/v1/photos", remote)
+	}
+
+	// rotating through a /64 doesn't buy a fresh burst
+	allowed := 0
+	for i := range 10 {
+		if request(fmt.Sprintf("[2001:db8:aa:bb::%x]:1000", i+1)) == http.StatusOK {
+			allowed++
+		}
+	}
+
+	if allowed != 5 {
+		t.Errorf("Expected one /64 to share a burst of 5, but got %d", allowed)
+	}
+
+	if code := request("[2001:db8:aa:bc::1]:1000"); code != http.StatusOK {
+		t.Errorf("Expected a neighbouring /64 to have its own burst, but got %d", code)
+	}
+
+	// mapped and plain IPv4 addresses share the /24
+	allowed = 0
+	for _, remote := range []string{"198.51.100.1:1", "[::ffff:198.51.100.2]:1", "198.51.100.3:1", "198.51.100.4:1", "[::ffff:198.51.100.5]:1", "198.51.100.6:1"} {
+		if request(remote) == http.StatusOK {
+			allowed++
+		}
+	}
+
+	if allowed != 5 {
+		t.Errorf("Expected one /24 to share a burst of 5, but got %d", allowed)
+	}
+
+	// automatic bans cover the whole group
+	if _, err := penalize(options.Limiter, "2001:db8:cc:dd::/64", 50, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := requestPath("/api/v1/auth", "[2001:db8:cc:dd::1]:1000"); code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, but got %d", http.StatusForbidden, code)
+	}
+
+	if code := request("[2001:db8:cc:dd:ffff::1]:1000"); code != http.StatusForbidden {
+		t.Errorf("Expected the ban to cover the /64, but got %d", code)
+	}
+
+	bans, err := store.Database.ListBans(false)
+	if err != nil || len(bans) != 1 || bans[0].CIDR.String() != "2001:db8:cc:dd::/64" {
+		t.Errorf("Expected one ban on 2001:db8:cc:dd::/64, but got %v %v", bans, err)
+	}
+}
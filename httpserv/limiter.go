@@ -0,0 +1,200 @@
+package httpserv
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+	"golang.org/x/time/rate"
+)
+
+// behavior scores decay by 5 points a minute
+const scoreDecay = 5.0 / 60
+
+// Limiter keeps token buckets, each refilling at its limit up to its burst. The limit
+// and burst are passed on every call so a bucket can be slowed down on the fly.
+// It also keeps the behavior scores of clients, which decay by scoreDecay points a second
+type Limiter interface {
+	Take(bucket string, limit rate.Limit, burst int, now time.Time) (LimitResult, error)
+	// AddScore adds points to the score of client, a client without a score starts at
+	// first instead. The new score is returned
+	AddScore(client string, points float64, first float64, now time.Time) (float64, error)
+	// ResetScore clears the score of client if it still reaches threshold, so only one of
+	// concurrent callers acts on it. The score it had is returned
+	ResetScore(client string, threshold float64, now time.Time) (float64, bool, error)
+	// Prune forgets buckets untouched since cutoff, a forgotten bucket starts full, and
+	// scores which had decayed to nothing by cutoff
+	Prune(cutoff time.Time) error
+}
+
+// LimitResult is the state of a bucket after Take
+type LimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// until the bucket is full again
+	Reset time.Duration
+	// until a refused request may be retried
+	RetryAfter time.Duration
+}
+
+func newLimitResult(allowed bool, tokens float64, limit rate.Limit, burst int) LimitResult {
+	tokens = max(tokens, 0)
+	result := LimitResult{Allowed: allowed, Limit: burst, Remaining: int(math.Floor(tokens))}
+	if limit <= 0 {
+		return result
+	}
+
+	if missing := float64(burst) - tokens; missing > 0 {
+		result.Reset = time.Duration(missing / float64(limit) * float64(time.Second))
+	}
+
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / float64(limit) * float64(time.Second))
+	}
+
+	return result
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type behaviorScore struct {
+	score   float64
+	updated time.Time
+}
+
+// decayed is the score left at now, a clock going backwards decays nothing
+func (state *behaviorScore) decayed(now time.Time) float64 {
+	return max(state.score-max(now.Sub(state.updated).Seconds(), 0)*scoreDecay, 0)
+}
+
+// MemoryLimiter keeps buckets and scores in process, every instance counts on its own
+type MemoryLimiter struct {
+	buckets map[string]*tokenBucket
+	scores  map[string]*behaviorScore
+	lock    sync.Mutex
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: make(map[string]*tokenBucket), scores: make(map[string]*behaviorScore)}
+}
+
+func (limiter *MemoryLimiter) Take(bucket string, limit rate.Limit, burst int, now time.Time) (LimitResult, error) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	state, ok := limiter.buckets[bucket]
+	if !ok {
+		state = &tokenBucket{tokens: float64(burst), updated: now}
+		limiter.buckets[bucket] = state
+	}
+
+	// same refill as the database limiter, a clock going backwards refills nothing
+	if elapsed := now.Sub(state.updated); elapsed > 0 {
+		state.tokens += elapsed.Seconds() * float64(limit)
+		state.updated = now
+	}
+	state.tokens = min(state.tokens, float64(burst))
+
+	if state.tokens < 1 {
+		return newLimitResult(false, state.tokens, limit, burst), nil
+	}
+
+	state.tokens--
+	return newLimitResult(true, state.tokens, limit, burst), nil
+}
+
+func (limiter *MemoryLimiter) AddScore(client string, points float64, first float64, now time.Time) (float64, error) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	state, ok := limiter.scores[client]
+	if !ok {
+		limiter.scores[client] = &behaviorScore{score: first, updated: now}
+		return first, nil
+	}
+
+	state.score = state.decayed(now) + points
+	if now.After(state.updated) {
+		state.updated = now
+	}
+
+	return state.score, nil
+}
+
+func (limiter *MemoryLimiter) ResetScore(client string, threshold float64, now time.Time) (float64, bool, error) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	state, ok := limiter.scores[client]
+	if !ok {
+		return 0, false, nil
+	}
+
+	score := state.decayed(now)
+	if score < threshold {
+		return 0, false, nil
+	}
+
+	delete(limiter.scores, client)
+	return score, true, nil
+}
+
+func (limiter *MemoryLimiter) Prune(cutoff time.Time) error {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	for bucket, state := range limiter.buckets {
+		if state.updated.Before(cutoff) {
+			delete(limiter.buckets, bucket)
+		}
+	}
+
+	for client, state := range limiter.scores {
+		if state.decayed(cutoff) <= 0 {
+			delete(limiter.scores, client)
+		}
+	}
+
+	return nil
+}
+
+// DatabaseLimiter keeps buckets and scores in the database, so every instance sharing
+// it shares the same allowance and bans a misbehaving client at the same score
+type DatabaseLimiter struct {
+	db *internal.Database
+}
+
+func NewDatabaseLimiter(db *internal.Database) *DatabaseLimiter {
+	return &DatabaseLimiter{db: db}
+}
+
+func (limiter *DatabaseLimiter) Take(bucket string, limit rate.Limit, burst int, now time.Time) (LimitResult, error) {
+	allowed, tokens, err := limiter.db.TakeToken(bucket, float64(limit), burst, now)
+	if err != nil {
+		return LimitResult{}, err
+	}
+
+	return newLimitResult(allowed, tokens, limit, burst), nil
+}
+
+func (limiter *DatabaseLimiter) AddScore(client string, points float64, first float64, now time.Time) (float64, error) {
+	return limiter.db.AddScore(client, points, first, scoreDecay, now)
+}
+
+func (limiter *DatabaseLimiter) ResetScore(client string, threshold float64, now time.Time) (float64, bool, error) {
+	return limiter.db.ResetScore(client, threshold, scoreDecay, now)
+}
+
+func (limiter *DatabaseLimiter) Prune(cutoff time.Time) error {
+	if _, err := limiter.db.PruneRateBuckets(cutoff); err != nil {
+		return err
+	}
+
+	_, err := limiter.db.PruneRateScores(cutoff, scoreDecay)
+	return err
+}
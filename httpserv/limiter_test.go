@@ -0,0 +1,202 @@
+package httpserv
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiters(t *testing.T) {
+	limiters := map[string]Limiter{
+		"memory":   NewMemoryLimiter(),
+		"database": NewDatabaseLimiter(internal.NewTestStore(t).Database),
+	}
+
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			testLimiter(t, limiter)
+		})
+	}
+}
+
+// testLimiter checks the semantics every Limiter has to share
+func testLimiter(t *testing.T, limiter Limiter) {
+	start := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+	take := func(bucket string, limit rate.Limit, at time.Duration) LimitResult {
+		result, err := limiter.Take(bucket, limit, 3, start.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	for i := range 3 {
+		result := take("a", 2, 0)
+		if !result.Allowed || result.Limit != 3 || result.Remaining != 2-i {
+			t.Fatalf("Expected request %d of the burst to be allowed with %d remaining, but got %+v", i+1, 2-i, result)
+		}
+	}
+
+	result := take("a", 2, 0)
+	if result.Allowed || result.RetryAfter != 500*time.Millisecond || result.Reset != 1500*time.Millisecond {
+		t.Errorf("Expected a refusal retrying after 500ms and a reset of 1.5s, but got %+v", result)
+	}
+
+	if result := take("b", 2, 0); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("Expected buckets to be independent, but got %+v", result)
+	}
+
+	// 2 tokens per second, so one token back after half a second
+	if result := take("a", 2, 500*time.Millisecond); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected a refilled token after 500ms, but got %+v", result)
+	}
+
+	// refilling stops at the burst
+	if result := take("a", 2, time.Hour); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("Expected the bucket to refill to its burst, but got %+v", result)
+	}
+
+	// a clock behind the last update neither refills nor breaks the bucket
+	if result := take("a", 2, time.Hour-time.Minute); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("Expected a clock going backwards to refill nothing, but got %+v", result)
+	}
+
+	// slowing a bucket down keeps its tokens
+	take("a", rate.Every(time.Minute), time.Hour)
+	if result := take("a", rate.Every(time.Minute), time.Hour); result.Allowed || result.RetryAfter != time.Minute {
+		t.Errorf("Expected the slower limit to apply, but got %+v", result)
+	}
+
+	score := func(points float64, at time.Duration) float64 {
+		score, err := limiter.AddScore("c", points, 2, start.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return score
+	}
+
+	if got := score(1, 0); got != 2 {
+		t.Errorf("Expected a new client to start at 2, but got %v", got)
+	}
+
+	// 5 points a minute decay
+	if got := score(49, time.Minute); got != 49 {
+		t.Errorf("Expected 2 to decay to 0 before adding 49, but got %v", got)
+	}
+
+	if _, reset, err := limiter.ResetScore("c", 50, start.Add(time.Minute)); err != nil || reset {
+		t.Errorf("Expected a score below the threshold to stay, but got %v %v", reset, err)
+	}
+
+	score(1, time.Minute)
+	if got, reset, err := limiter.ResetScore("c", 50, start.Add(time.Minute)); err != nil || !reset || got != 50 {
+		t.Errorf("Expected a score of 50 to be reset, but got %v %v %v", got, reset, err)
+	}
+
+	if _, reset, _ := limiter.ResetScore("c", 50, start.Add(time.Minute)); reset {
+		t.Error("Expected a score to be reset only once")
+	}
+
+	score(10, 0)
+	if err := limiter.Prune(start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := score(1, time.Hour); got != 2 {
+		t.Errorf("Expected a decayed score to be pruned, but got %v", got)
+	}
+
+	if result := take("b", 2, time.Hour); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("Expected a pruned bucket to start full, but got %+v", result)
+	}
+}
+
+func TestDatabaseLimiterShared(t *testing.T) {
+	path := "file:" + filepath.Join(t.TempDir(), "shared.sqlite")
+
+	var instances []Limiter
+	for range 2 {
+		db, err := internal.NewDBConnection(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		instances = append(instances, NewDatabaseLimiter(db))
+	}
+
+	now := time.Now()
+	allowed := 0
+	for i := range 10 {
+		result, err := instances[i%2].Take("default/ip/192.0.2.1", 1, 4, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Allowed {
+			allowed++
+		}
+	}
+
+	if allowed != 4 {
+		t.Errorf("Expected both instances to share a burst of 4, but got %d", allowed)
+	}
+}
+
+func TestDatabaseLimiterConcurrency(t *testing.T) {
+	path := "file:" + filepath.Join(t.TempDir(), "shared.sqlite")
+	var limiters []Limiter
+	for range 2 {
+		db, err := internal.NewDBConnection(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		limiters = append(limiters, NewDatabaseLimiter(db))
+	}
+
+	// two instances hammering one bucket which never refills
+	const burst = 50
+	var allowed atomic.Int64
+	var running sync.WaitGroup
+	errs := make(chan error, 16)
+	now := time.Now()
+	for i := range 16 {
+		limiter := limiters[i%2]
+		running.Go(func() {
+			for range 200 {
+				result, err := limiter.Take("shared", 0, burst, now)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				if result.Allowed {
+					allowed.Add(1)
+				}
+			}
+		})
+	}
+	running.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Expected concurrent takes to wait for the lock, but got %v", err)
+	}
+
+	if allowed.Load() != burst {
+		t.Errorf("Expected exactly %d tokens to be granted, but got %d", burst, allowed.Load())
+	}
+}
@@ -0,0 +1,314 @@
+package httpserv
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+	"github.com/Y2Kwastaken/gdn/rest"
+	"golang.org/x/time/rate"
+)
+
+// recovery turns a panicking handler into a 500 instead of a dropped connection
+func recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Printf("[SEVERE] panic serving %s %s: %v\n%s", rqst.Method, rqst.URL.Path, err, debug.Stack())
+			http.Error(rspn, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(rspn, rqst)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (recorder *statusRecorder) WriteHeader(code int) {
+	if recorder.status == 0 {
+		recorder.status = code
+	}
+	recorder.ResponseWriter.WriteHeader(code)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+
+	n, err := recorder.ResponseWriter.Write(data)
+	recorder.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the real writer
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rspn}
+		next.ServeHTTP(recorder, rqst)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		ip, _, _ := net.SplitHostPort(rqst.RemoteAddr)
+		log.Printf("%s %s %s %d %dB %s\n", ip, rqst.Method, rqst.URL.Path, recorder.status, recorder.written, time.Since(start).Round(time.Millisecond))
+	})
+}
+
+// rateLimit refuses banned clients and throttles the rest by the first matching
+// policy, clients whose behavior score reaches autoBanScore under a distrusting
+// policy are banned for autoBanDuration
+func rateLimit(store *FileStore, options Options) Middleware {
+	policies, limiter := options.LimitPolicies, options.Limiter
+	keyed := needsKey(policies)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+			ip, _, err := net.SplitHostPort(rqst.RemoteAddr)
+			if err != nil {
+				rspn.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			// clients are grouped by prefix for everything but matching bans
+			client, _ := options.Aggregation.client(rqst.RemoteAddr)
+
+			if addr, err := netip.ParseAddr(ip); err == nil {
+				ban, err := store.Database.Banned(addr)
+				if err != nil {
+					http.Error(rspn, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					log.Println(err)
+					return
+				}
+
+				if ban != nil {
+					writeBanned(rspn, ban)
+					return
+				}
+			}
+
+			key := ""
+			if keyed {
+				rqst, key = limitKey(store, rqst)
+			}
+
+			policy, now := matchPolicy(policies, rqst, key), time.Now()
+			limit, bucket := rate.Limit(policy.Rate), policy.Name+"/ip/"+client
+			if len(policy.Keys) > 0 {
+				bucket = policy.Name + "/key/" + key
+			} else if policy.Distrust {
+				score, err := onSiteVisit(limiter, client, policy, now)
+				if err != nil {
+					http.Error(rspn, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					log.Println(err)
+					return
+				}
+
+				if score >= autoBanScore {
+					ban, err := autoBan(store, limiter, client, now)
+					if err != nil {
+						log.Println(err)
+					}
+
+					if ban != nil {
+						writeBanned(rspn, ban)
+					} else {
+						http.Error(rspn, "Temporarily Banned", http.StatusForbidden)
+					}
+					return
+				}
+				limit = policy.limit(score)
+			}
+
+			result, err := limiter.Take(bucket, limit, policy.Burst, now)
+			if err != nil {
+				http.Error(rspn, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+
+			writeLimitHeaders(rspn, result)
+			if !result.Allowed {
+				rspn.Header().Set("Retry-After", retryAfter(result.RetryAfter))
+				http.Error(rspn, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(rspn, rqst)
+		})
+	}
+}
+
+// limitKey names the key a request is authenticated with for key policies, requests
+// failing authentication are limited as anonymous and rejected later on. The returned
+// request carries the outcome so nothing after authenticates it again
+func limitKey(store *FileStore, rqst *http.Request) (*http.Request, string) {
+	if rqst.Header.Get("X-API-Key") == "" {
+		if _, err := rqst.Cookie(rest.SessionCookie); err != nil {
+			return rqst, ""
+		}
+	}
+
+	rqst, key, err := rest.WithAuthentication(store, rqst)
+	if err != nil || key == nil {
+		return rqst, ""
+	}
+
+	return rqst, key.Name
+}
+
+// autoBan persists the ban of a misbehaving client and resets its score, so lifting
+// the ban lets the client start over. nil is returned if another request won the race
+func autoBan(store *FileStore, limiter Limiter, client string, now time.Time) (*internal.Ban, error) {
+	score, reset, err := limiter.ResetScore(client, autoBanScore, now)
+	if err != nil || !reset {
+		return nil, err
+	}
+
+	target, err := internal.ParsePrefix(client)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := fmt.Sprintf("behavior score reached %d", int(score))
+	ban, err := store.Database.AddBan(target, reason, internal.BanAutomatic, "", now.Add(autoBanDuration))
+	if err != nil {
+		return nil, err
+	}
+
+	internal.Audit("ban.automatic", "client", client, "score", int(score), "until", ban.ExpiresAt.Format(time.RFC3339))
+	return ban, nil
+}
+
+func writeBanned(rspn http.ResponseWriter, ban *internal.Ban) {
+	if ban.ExpiresAt.IsZero() {
+		http.Error(rspn, "Banned", http.StatusForbidden)
+		return
+	}
+
+	rspn.Header().Set("Retry-After", retryAfter(time.Until(ban.ExpiresAt)))
+	http.Error(rspn, "Temporarily Banned", http.StatusForbidden)
+}
+
+// writeLimitHeaders reports the bucket as RateLimit-* headers, the limit is the burst
+// and reset is how long until the bucket is full again
+func writeLimitHeaders(rspn http.ResponseWriter, result LimitResult) {
+	header := rspn.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+}
+
+// retryAfter formats a wait in whole seconds, rounded up so clients never retry early
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1))
+}
+
+// authGuard refuses X-API-Key attempts from locked out clients and records the outcome
+// of attempts which were let through. Failures escalate the lockout and count against
+// the client's behavior score, which bans it once it reaches autoBanScore
+func authGuard(store *FileStore, options Options) Middleware {
+	limiter, agg := options.Limiter, options.Aggregation
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+			client, err := agg.client(rqst.RemoteAddr)
+			if err != nil {
+				rspn.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if rqst.Header.Get("X-API-Key") != "" {
+				if until, locked := store.Lockouts.Locked(client); locked {
+					internal.Audit("auth.locked", "client", client, "path", rqst.URL.Path, "until", until.Format(time.RFC3339))
+					rspn.Header().Set("Retry-After", retryAfter(time.Until(until)))
+					http.Error(rspn, "Too many failed authentication attempts", http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			rqst, attempt := rest.TrackAuth(rqst)
+			next.ServeHTTP(rspn, rqst)
+
+			if !attempt.Presented {
+				return
+			}
+
+			if attempt.Key != nil {
+				if store.Lockouts.Clear(client) {
+					internal.Audit("auth.success", "client", client, "key", attempt.Key.Name)
+				}
+				return
+			}
+
+			entry := store.Lockouts.Fail(client)
+			internal.Audit("auth.failure", "client", client, "path", rqst.URL.Path, "failures", entry.Failures, "locked_until", entry.LockedUntil.Format(time.RFC3339))
+
+			now := time.Now()
+			score, err := penalize(limiter, client, entry.Failures, now)
+			if err == nil && score >= autoBanScore {
+				_, err = autoBan(store, limiter, client, now)
+			}
+
+			if err != nil {
+				log.Println(err)
+			}
+		})
+	}
+}
+
+// requireScope only lets requests through whose X-API-Key or session grants scope, the
+// key is stored in the request context for the handler
+func requireScope(store *FileStore, scope string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+			key, _, err := rest.Authenticate(store, rqst)
+			if errors.Is(err, rest.ErrCSRF) {
+				http.Error(rspn, err.Error(), http.StatusForbidden)
+				return
+			}
+
+			if err != nil {
+				http.Error(rspn, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				log.Println(err)
+				return
+			}
+
+			if key == nil {
+				http.Error(rspn, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+
+			if !key.HasScope(scope) {
+				http.Error(rspn, "API key lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(rspn, rqst.WithContext(internal.WithAPIKey(rqst.Context(), key)))
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package httpserv
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+func TestAuthGuard(t *testing.T) {
+	store := internal.NewTestStore(t)
+	t.Setenv("ADMIN_SECRET", "very_secure_secret")
+
+	options := Options{}.withDefaults()
+	router := NewRouter()
+	router.Use(authGuard(store, options))
+	registerEndpoints(router, store)
+
+	request := func(key string) *httptest.ResponseRecorder {
+		rqst := httptest.NewRequest(http.MethodGet, "/api/v1/admin/lockouts", nil)
+		rqst.RemoteAddr = "192.0.2.7:4321"
+		rqst.Header.Set("X-API-Key", key)
+		rspn := httptest.NewRecorder()
+		router.ServeHTTP(rspn, rqst)
+		return rspn
+	}
+
+	for range 4 {
+		if rspn := request("wrong"); rspn.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d, but got %d", http.StatusUnauthorized, rspn.Code)
+		}
+	}
+
+	rspn := request("very_secure_secret")
+	if rspn.Code != http.StatusTooManyRequests || rspn.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected a locked out client to get %d with Retry-After, but got %d %v", http.StatusTooManyRequests, rspn.Code, rspn.Header())
+	}
+
+	list := store.Lockouts.List()
+	if len(list) != 1 || list[0].Client != "192.0.2.7" || list[0].Failures != 4 {
+		t.Fatalf("Expected one lockout for 192.0.2.7, but got %v", list)
+	}
+
+	store.Lockouts.Clear("192.0.2.7")
+	if rspn := request("very_secure_secret"); rspn.Code != http.StatusOK {
+		t.Errorf("Expected status %d once cleared, but got %d", http.StatusOK, rspn.Code)
+	}
+
+	// adding nothing reads the score, which has barely decayed since
+	if score, err := options.Limiter.AddScore("192.0.2.7", 0, 0, time.Now()); err != nil || math.Round(score) != 1+2+3+4 {
+		t.Errorf("Expected failures to count against the behavior score, but got %v %v", score, err)
+	}
+}
+
+func TestAutoBan(t *testing.T) {
+	store := internal.NewTestStore(t)
+
+	options := Options{}.withDefaults()
+	router := NewRouter()
+	router.Use(rateLimit(store, options))
+	router.HandleFunc(http.MethodGet, "/api/v1/auth", func(http.ResponseWriter, *http.Request) {})
+
+	request := func(remote string) int {
+		rqst := httptest.NewRequest(http.MethodGet, "/api/v1/auth", nil)
+		rqst.RemoteAddr = remote
+		rspn := httptest.NewRecorder()
+		router.ServeHTTP(rspn, rqst)
+		return rspn.Code
+	}
+
+	if code := request("198.51.100.4:1000"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, but got %d", http.StatusOK, code)
+	}
+
+	if _, err := penalize(options.Limiter, "198.51.100.4", 50, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := request("198.51.100.4:1000"); code != http.StatusForbidden {
+		t.Fatalf("Expected status %d once the score reached 50, but got %d", http.StatusForbidden, code)
+	}
+
+	bans, err := store.Database.ListBans(false)
+	if err != nil || len(bans) != 1 || bans[0].Origin != internal.BanAutomatic || bans[0].CIDR.String() != "198.51.100.4/32" {
+		t.Fatalf("Expected one automatic ban, but got %v %v", bans, err)
+	}
+
+	// the ban outlives the score, which was reset
+	if code := request("198.51.100.4:1000"); code != http.StatusForbidden {
+		t.Errorf("Expected the persisted ban to apply, but got %d", code)
+	}
+
+	if err := store.Database.DeleteBan(bans[0].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := request("198.51.100.4:1000"); code != http.StatusOK {
+		t.Errorf("Expected status %d once unbanned, but got %d", http.StatusOK, code)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	store := internal.NewTestStore(t)
+
+	router := NewRouter()
+	router.Use(rateLimit(store, Options{}.withDefaults()))
+	router.HandleFunc(http.MethodGet, "/api/v1/photos", func(http.ResponseWriter, *http.Request) {})
+
+	request := func(remote string) *httptest.ResponseRecorder {
+		rqst := httptest.NewRequest(http.MethodGet, "/api/v1/photos", nil)
+		rqst.RemoteAddr = remote
+		rspn := httptest.NewRecorder()
+		router.ServeHTTP(rspn, rqst)
+		return rspn
+	}
+
+	var rspn *httptest.ResponseRecorder
+	for range 5 {
+		rspn = request("198.51.100.9:1000")
+		if rspn.Code != http.StatusOK || rspn.Header().Get("RateLimit-Limit") != "5" {
+			t.Fatalf("Expected status %d with RateLimit-Limit 5, but got %d %v", http.StatusOK, rspn.Code, rspn.Header())
+		}
+	}
+
+	if remaining := rspn.Header().Get("RateLimit-Remaining"); remaining != "0" {
+		t.Errorf("Expected no requests remaining after the burst, but got %s", remaining)
+	}
+
+	if reset := rspn.Header().Get("RateLimit-Reset"); reset != "1" {
+		t.Errorf("Expected the bucket to refill within a second, but got %s", reset)
+	}
+
+	rspn = request("198.51.100.9:1000")
+	if rspn.Code != http.StatusTooManyRequests || rspn.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected status %d with Retry-After 1, but got %d %v", http.StatusTooManyRequests, rspn.Code, rspn.Header())
+	}
+
+	target, _ := internal.ParsePrefix("198.51.100.10")
+	if _, err := store.Database.AddBan(target, "", internal.BanManual, "", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	rspn = request("198.51.100.10:1000")
+	if retry := rspn.Header().Get("Retry-After"); rspn.Code != http.StatusForbidden || retry != "60" && retry != "59" {
+		t.Errorf("Expected status %d with Retry-After until the ban expires, but got %d %v", http.StatusForbidden, rspn.Code, rspn.Header())
+	}
+}
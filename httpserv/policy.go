@@ -0,0 +1,182 @@
+package httpserv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+// LimitPolicy is a token bucket applied to matching requests. Empty Paths, Methods or
+// Keys match anything. Policies with Keys only match requests authenticated as one of
+// those keys, "*" being any key, and share one bucket per key instead of per client
+type LimitPolicy struct {
+	Name    string   `yaml:"name"`
+	Paths   []string `yaml:"paths"`
+	Methods []string `yaml:"methods"`
+	Keys    []string `yaml:"keys"`
+	// requests per second and how many may arrive at once
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+	// distrusting policies count every request against the behavior score and slow
+	// clients down as it grows, meant for authentication
+	Distrust bool `yaml:"distrust"`
+	// every DistrustStep points the client is slowed to DistrustRate / score requests
+	// per second, zero values take the defaults of 10 points and 10 per hour
+	DistrustStep int     `yaml:"distrust_step"`
+	DistrustRate float64 `yaml:"distrust_rate"`
+}
+
+const (
+	defaultDistrustStep = 10
+	defaultDistrustRate = 10.0 / 3600
+)
+
+type limitPolicies struct {
+	Policies []LimitPolicy `yaml:"policies"`
+}
+
+// DefaultLimitPolicies are used when no policies are configured, authentication is
+// distrusted at 5 req/s and everything else gets 10 req/s
+var DefaultLimitPolicies = []LimitPolicy{
+	{Name: "auth", Paths: []string{"/api/v1/auth"}, Rate: 5, Burst: 5, Distrust: true},
+	{Name: "default", Rate: 10, Burst: 5},
+}
+
+// LoadLimitPolicies reads policies from a YAML file. Policies are tried in order and
+// the first match wins, requests matching none fall back to the last default policy
+func LoadLimitPolicies(path string) ([]LimitPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file limitPolicies
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if err := ValidateLimitPolicies(file.Policies); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return file.Policies, nil
+}
+
+func ValidateLimitPolicies(policies []LimitPolicy) error {
+	if len(policies) == 0 {
+		return errors.New("at least one rate limit policy is required")
+	}
+
+	names := make(map[string]bool)
+	for i := range policies {
+		policy := &policies[i]
+		if policy.Name == "" {
+			return fmt.Errorf("rate limit policy %d has no name", i+1)
+		}
+
+		if names[policy.Name] {
+			return fmt.Errorf("duplicate rate limit policy %q", policy.Name)
+		}
+		names[policy.Name] = true
+
+		if policy.Rate <= 0 || policy.Burst < 1 {
+			return fmt.Errorf("rate limit policy %q needs a positive rate and burst", policy.Name)
+		}
+
+		if policy.Distrust && len(policy.Keys) > 0 {
+			return fmt.Errorf("rate limit policy %q can't distrust key buckets", policy.Name)
+		}
+
+		if policy.DistrustStep < 0 || policy.DistrustRate < 0 {
+			return fmt.Errorf("rate limit policy %q has a negative distrust_step or distrust_rate", policy.Name)
+		}
+
+		for _, path := range policy.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("rate limit policy %q has path %q not starting with /", policy.Name, path)
+			}
+		}
+
+		for j, method := range policy.Methods {
+			policy.Methods[j] = strings.ToUpper(method)
+		}
+	}
+
+	if fallback := policies[len(policies)-1]; len(fallback.Paths) > 0 || len(fallback.Methods) > 0 || len(fallback.Keys) > 0 {
+		return fmt.Errorf("the last rate limit policy %q must match every request", fallback.Name)
+	}
+
+	return nil
+}
+
+// matchPolicy returns the first policy matching the request, key is the name of the
+// authenticated key or empty
+func matchPolicy(policies []LimitPolicy, rqst *http.Request, key string) *LimitPolicy {
+	for i := range policies {
+		policy := &policies[i]
+		if len(policy.Keys) > 0 && (key == "" || !slices.Contains(policy.Keys, "*") && !slices.Contains(policy.Keys, key)) {
+			continue
+		}
+
+		if len(policy.Methods) > 0 && !slices.Contains(policy.Methods, rqst.Method) {
+			continue
+		}
+
+		if len(policy.Paths) > 0 && !slices.ContainsFunc(policy.Paths, func(path string) bool { return underPath(rqst.URL.Path, path) }) {
+			continue
+		}
+
+		return policy
+	}
+
+	return &policies[len(policies)-1]
+}
+
+// underPath reports whether path is prefix itself or below it
+func underPath(path string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || strings.HasPrefix(rest, "/"))
+}
+
+// needsKey reports whether any policy depends on the authenticated key
+func needsKey(policies []LimitPolicy) bool {
+	return slices.ContainsFunc(policies, func(policy LimitPolicy) bool { return len(policy.Keys) > 0 })
+}
+
+func (policy *LimitPolicy) distrustStep() int {
+	if policy.DistrustStep > 0 {
+		return policy.DistrustStep
+	}
+	return defaultDistrustStep
+}
+
+func (policy *LimitPolicy) distrustRate() float64 {
+	if policy.DistrustRate > 0 {
+		return policy.DistrustRate
+	}
+	return defaultDistrustRate
+}
+
+// distrustedLimit is the rate of a distrusting policy once the behavior score reached
+// score, the higher the score the slower but never faster than the policy itself
+func distrustedLimit(policy *LimitPolicy, score int) rate.Limit {
+	return rate.Limit(min(policy.distrustRate()/float64(max(score, 1)), policy.Rate))
+}
+
+// limit is the rate a client with score is held to, under distrusting policies every
+// DistrustStep points slow it down further until the score decays again
+func (policy *LimitPolicy) limit(score float64) rate.Limit {
+	step := policy.distrustStep()
+	if !policy.Distrust || score < float64(step) {
+		return rate.Limit(policy.Rate)
+	}
+
+	return distrustedLimit(policy, int(score)/step*step)
+}
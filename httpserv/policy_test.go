@@ -0,0 +1,205 @@
+package httpserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+	"golang.org/x/time/rate"
+)
+
+const testPolicies = `
+policies:
+  - name: uploads
+    keys: [uploader]
+    methods: [put, patch]
+    paths: [/api/v1/photos]
+    rate: 50
+    burst: 20
+  - name: listing
+    methods: [GET]
+    paths: [/api/v1/photos/]
+    rate: 2
+    burst: 2
+  - name: auth
+    paths: [/api/v1/auth]
+    rate: 5
+    burst: 5
+    distrust: true
+  - name: default
+    rate: 10
+    burst: 5
+`
+
+func TestLoadLimitPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	if err := os.WriteFile(path, []byte(testPolicies), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := LoadLimitPolicies(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method   string
+		path     string
+		key      string
+		expected string
+	}{
+		{http.MethodPut, "/api/v1/photos", "uploader", "uploads"},
+		{http.MethodPatch, "/api/v1/photos/abc", "uploader", "uploads"},
+		{http.MethodPut, "/api/v1/photos", "someone", "default"},
+		{http.MethodPut, "/api/v1/photos", "", "default"},
+		{http.MethodGet, "/api/v1/photos", "uploader", "listing"},
+		{http.MethodGet, "/api/v1/photosearch", "", "default"},
+		{http.MethodPost, "/api/v1/auth", "", "auth"},
+		{http.MethodDelete, "/api/v1/admin/bans/1", "", "default"},
+	}
+
+	for _, test := range tests {
+		rqst := httptest.NewRequest(test.method, test.path, nil)
+		if policy := matchPolicy(policies, rqst, test.key); policy.Name != test.expected {
+			t.Errorf("Expected %s %s as %q to match %s, but got %s", test.method, test.path, test.key, test.expected, policy.Name)
+		}
+	}
+
+	invalid := map[string][]LimitPolicy{
+		"empty":          {},
+		"no name":        {{Rate: 1, Burst: 1}},
+		"duplicate":      {{Name: "a", Rate: 1, Burst: 1}, {Name: "a", Rate: 1, Burst: 1}},
+		"no rate":        {{Name: "a", Burst: 1}},
+		"relative path":  {{Name: "a", Paths: []string{"api"}, Rate: 1, Burst: 1}, {Name: "b", Rate: 1, Burst: 1}},
+		"no fallback":    {{Name: "a", Methods: []string{"GET"}, Rate: 1, Burst: 1}},
+		"distrust a key": {{Name: "a", Keys: []string{"*"}, Distrust: true, Rate: 1, Burst: 1}, {Name: "b", Rate: 1, Burst: 1}},
+		"negative step":  {{Name: "a", Distrust: true, DistrustStep: -1, Rate: 1, Burst: 1}},
+	}
+
+	for name, policies := range invalid {
+		if err := ValidateLimitPolicies(policies); err == nil {
+			t.Errorf("Expected %s policies to be rejected", name)
+		}
+	}
+}
+
+func TestKeyLimitPolicy(t *testing.T) {
+	store := internal.NewTestStore(t)
+	t.Setenv("ADMIN_SECRET", "")
+
+	_, secret, err := store.Database.CreateAPIKey("bulk", []string{internal.ScopePhotosWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies := []LimitPolicy{
+		{Name: "bulk", Keys: []string{"bulk"}, Rate: 1, Burst: 8},
+		{Name: "default", Rate: 1, Burst: 2},
+	}
+
+	router := NewRouter()
+	router.Use(rateLimit(store, Options{LimitPolicies: policies}.withDefaults()))
+	router.HandleFunc(http.MethodGet, "/api/v1/photos", func(http.ResponseWriter, *http.Request) {})
+
+	allowed := func(remote string, key string) int {
+		count := 0
+		for range 10 {
+			rqst := httptest.NewRequest(http.MethodGet, "/api/v1/photos", nil)
+			rqst.RemoteAddr = remote
+			if key != "" {
+				rqst.Header.Set("X-API-Key", key)
+			}
+
+			rspn := httptest.NewRecorder()
+			router.ServeHTTP(rspn, rqst)
+			if rspn.Code == http.StatusOK {
+				count++
+			}
+		}
+		return count
+	}
+
+	if count := allowed("203.0.113.20:1000", ""); count != 2 {
+		t.Errorf("Expected anonymous clients to get a burst of 2, but got %d", count)
+	}
+
+	if count := allowed("203.0.113.21:1000", "gdn_wrong_key"); count != 2 {
+		t.Errorf("Expected invalid keys to be limited as anonymous, but got %d", count)
+	}
+
+	// the key's bucket is shared no matter where the key is used from
+	if count := allowed("203.0.113.22:1000", secret) + allowed("203.0.113.23:1000", secret); count != 8 {
+		t.Errorf("Expected the key to get a burst of 8 across clients, but got %d", count)
+	}
+}
+
+func TestLimitKeyAuthenticatesOnce(t *testing.T) {
+	store := internal.NewTestStore(t)
+	t.Setenv("ADMIN_SECRET", "")
+
+	_, secret, err := store.Database.CreateAPIKey("bulk", []string{internal.ScopePhotosWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies := []LimitPolicy{{Name: "bulk", Keys: []string{"*"}, Rate: 10, Burst: 10}, {Name: "default", Rate: 10, Burst: 10}}
+	router := NewRouter()
+	router.Use(rateLimit(store, Options{LimitPolicies: policies}.withDefaults()), authGuard(store, Options{}.withDefaults()))
+
+	var seen *internal.APIKey
+	router.HandleFunc(http.MethodGet, "/api/v1/photos", func(rspn http.ResponseWriter, rqst *http.Request) {
+		seen = internal.APIKeyFrom(rqst.Context())
+	})
+	router.HandleFunc(http.MethodPut, "/api/v1/photos", func(http.ResponseWriter, *http.Request) {}, requireScope(store, internal.ScopePhotosWrite))
+
+	request := func(method string, key string) int {
+		rqst := httptest.NewRequest(method, "/api/v1/photos", nil)
+		rqst.RemoteAddr = "203.0.113.30:1000"
+		rqst.Header.Set("X-API-Key", key)
+		rspn := httptest.NewRecorder()
+		router.ServeHTTP(rspn, rqst)
+		return rspn.Code
+	}
+
+	// the key found by the rate limiter reaches unscoped handlers through the context
+	if code := request(http.MethodGet, secret); code != http.StatusOK || seen == nil || seen.Name != "bulk" {
+		t.Errorf("Expected the handler to see the bulk key, but got %d %v", code, seen)
+	}
+
+	// reused outcomes still count as failed attempts
+	if code := request(http.MethodPut, "gdn_wrong_key"); code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong key to be refused, but got %d", code)
+	}
+
+	if list := store.Lockouts.List(); len(list) != 1 || list[0].Failures != 1 {
+		t.Errorf("Expected one recorded failure, but got %v", list)
+	}
+}
+
+func TestDistrustedLimit(t *testing.T) {
+	policy := &DefaultLimitPolicies[0]
+	previous := rate.Limit(policy.Rate)
+	for score := 10; score <= 50; score += 10 {
+		limit := distrustedLimit(policy, score)
+		if limit <= 0 || limit >= previous {
+			t.Errorf("Expected score %d to slow below %v, but got %v", score, previous, limit)
+		}
+		previous = limit
+	}
+
+	if got, expected := distrustedLimit(policy, 20), rate.Limit(10.0/3600/20); got != expected {
+		t.Errorf("Expected the default 10 per hour divided by the score %v, but got %v", expected, got)
+	}
+
+	custom := &LimitPolicy{Name: "login", Rate: 1, Burst: 1, Distrust: true, DistrustStep: 5, DistrustRate: 100}
+	if got := distrustedLimit(custom, 5); got != 1 {
+		t.Errorf("Expected the slowdown to be capped at the policy rate, but got %v", got)
+	}
+
+	if got := distrustedLimit(custom, 200); got != 0.5 {
+		t.Errorf("Expected distrust_rate / score, but got %v", got)
+	}
+}
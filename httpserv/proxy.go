@@ -0,0 +1,149 @@
+package httpserv
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+// DefaultClientIPHeader is the forwarding header read unless another is configured
+const DefaultClientIPHeader = "x-forwarded-for"
+
+// ClientIPHeaders are the forwarding headers the client address can be read from. Only
+// the one the trusted proxies write may be used, proxies pass the others on from the
+// client untouched
+var ClientIPHeaders = []string{"x-forwarded-for", "forwarded", "x-real-ip"}
+
+func ValidateClientIPHeader(name string) error {
+	if !slices.Contains(ClientIPHeaders, strings.ToLower(name)) {
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(ClientIPHeaders, ", "), name)
+	}
+
+	return nil
+}
+
+// ParseTrustedProxies reads a comma separated list of proxy addresses and CIDR ranges
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for entry := range strings.SplitSeq(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		prefix, err := internal.ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix)
+	}
+
+	return proxies, nil
+}
+
+// realIP rewrites RemoteAddr to the client address reported by trusted proxies, so the
+// rate limiter, bans, auth logging and the access log all see the same client. Only
+// the header named by clientHeader is read, its hops are walked right to left and the
+// first hop which isn't a trusted proxy is the client. Headers from untrusted peers
+// are ignored
+func realIP(proxies []netip.Prefix, clientHeader string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(proxies) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+			host, port, err := net.SplitHostPort(rqst.RemoteAddr)
+			if err != nil {
+				next.ServeHTTP(rspn, rqst)
+				return
+			}
+
+			peer, err := netip.ParseAddr(host)
+			if err != nil || !trusted(proxies, peer) {
+				next.ServeHTTP(rspn, rqst)
+				return
+			}
+
+			client := peer
+			for _, hop := range slices.Backward(forwardedFor(rqst.Header, clientHeader)) {
+				addr, ok := parseHop(hop)
+				if !ok {
+					break
+				}
+
+				client = addr
+				if !trusted(proxies, addr) {
+					break
+				}
+			}
+
+			if client != peer {
+				rqst = rqst.Clone(rqst.Context())
+				rqst.RemoteAddr = net.JoinHostPort(client.String(), port)
+			}
+
+			next.ServeHTTP(rspn, rqst)
+		})
+	}
+}
+
+func trusted(proxies []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap().WithZone("")
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedFor returns the hops recorded in the header named by clientHeader, oldest
+// first. The other forwarding headers are never read, a client could have sent them
+func forwardedFor(header http.Header, clientHeader string) []string {
+	var hops []string
+	switch strings.ToLower(clientHeader) {
+	case "forwarded":
+		for _, line := range header.Values("Forwarded") {
+			for element := range strings.SplitSeq(line, ",") {
+				for pair := range strings.SplitSeq(element, ";") {
+					key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+	case "x-forwarded-for":
+		for _, line := range header.Values("X-Forwarded-For") {
+			for hop := range strings.SplitSeq(line, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	case "x-real-ip":
+		if value := strings.TrimSpace(header.Get("X-Real-IP")); value != "" {
+			hops = append(hops, value)
+		}
+	}
+
+	return hops
+}
+
+// parseHop reads a single hop, which may carry a port and brackets
+func parseHop(hop string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), true
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap().WithZone(""), true
+}
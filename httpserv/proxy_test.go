@@ -0,0 +1,71 @@
+package httpserv
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("127.0.0.1, 10.0.0.0/8,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		remote   string
+		headers  http.Header
+		expected string
+	}{
+		{"untrusted peer", "x-forwarded-for", "203.0.113.9:1000", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, "203.0.113.9"},
+		{"no header", "x-forwarded-for", "127.0.0.1:1000", http.Header{}, "127.0.0.1"},
+		{"x-forwarded-for", "x-forwarded-for", "127.0.0.1:1000", http.Header{"X-Forwarded-For": {"192.0.2.1"}}, "192.0.2.1"},
+		{"spoofed hops are skipped", "x-forwarded-for", "127.0.0.1:1000", http.Header{"X-Forwarded-For": {"6.6.6.6, 192.0.2.1, 10.1.2.3"}}, "192.0.2.1"},
+		{"multiple header lines", "x-forwarded-for", "127.0.0.1:1000", http.Header{"X-Forwarded-For": {"6.6.6.6", "192.0.2.1"}}, "192.0.2.1"},
+		{"all hops trusted", "x-forwarded-for", "127.0.0.1:1000", http.Header{"X-Forwarded-For": {"10.0.0.2, 10.0.0.1"}}, "10.0.0.2"},
+		{"garbage stops the walk", "x-forwarded-for", "127.0.0.1:1000", http.Header{"X-Forwarded-For": {"192.0.2.1, unknown, 10.0.0.1"}}, "10.0.0.1"},
+		{"forwarded", "forwarded", "[::1]:1000", http.Header{"Forwarded": {`for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.1`}}, "2001:db8:cafe::17"},
+		{"x-real-ip", "X-Real-IP", "127.0.0.1:1000", http.Header{"X-Real-Ip": {"192.0.2.3"}}, "192.0.2.3"},
+		{"mapped addresses", "x-forwarded-for", "[::ffff:10.0.0.1]:1000", http.Header{"X-Forwarded-For": {"::ffff:192.0.2.4"}}, "192.0.2.4"},
+		// headers the proxy doesn't write are passed on from the client, so they're never read
+		{"client forwarded ignored", "x-forwarded-for", "127.0.0.1:1000", http.Header{"Forwarded": {"for=6.6.6.6"}, "X-Forwarded-For": {"192.0.2.2"}}, "192.0.2.2"},
+		{"client forwarded alone ignored", "x-forwarded-for", "127.0.0.1:1000", http.Header{"Forwarded": {"for=6.6.6.6"}}, "127.0.0.1"},
+		{"client x-forwarded-for ignored", "x-real-ip", "127.0.0.1:1000", http.Header{"X-Forwarded-For": {"6.6.6.6"}, "X-Real-Ip": {"192.0.2.3"}}, "192.0.2.3"},
+		{"client x-real-ip ignored", "forwarded", "127.0.0.1:1000", http.Header{"X-Real-Ip": {"6.6.6.6"}, "X-Forwarded-For": {"6.6.6.7"}}, "127.0.0.1"},
+	}
+
+	for _, test := range tests {
+		var seen string
+		handler := realIP(proxies, test.header)(http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+			seen = rqst.RemoteAddr
+		}))
+
+		rqst := httptest.NewRequest(http.MethodGet, "/", nil)
+		rqst.RemoteAddr = test.remote
+		rqst.Header = test.headers
+		handler.ServeHTTP(httptest.NewRecorder(), rqst)
+
+		if host := hostOf(t, seen); host != test.expected {
+			t.Errorf("%s: expected client %s, but got %s", test.name, test.expected, host)
+		}
+	}
+
+	if err := ValidateClientIPHeader("x-client-ip"); err == nil {
+		t.Errorf("Expected unknown client ip headers to be rejected")
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/8,localhost"); err == nil {
+		t.Errorf("Expected host names to be rejected")
+	}
+}
+
+func hostOf(t *testing.T, addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Invalid RemoteAddr %q: %v", addr, err)
+	}
+	return host
+}
@@ -1,88 +1,54 @@
 package httpserv
 
 import (
+	"context"
 	"log"
 	"math"
-	"sync"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
-type user struct {
-	limiter       *rate.Limiter
-	lastRequest   time.Time
-	behaviorScore int
-	ulock         sync.RWMutex
-}
+// how long a client is banned for once its behavior score reaches autoBanScore
+const autoBanDuration = time.Hour
 
-var (
-	users        = make(map[string]*user)
-	rwlock       sync.RWMutex
-	cleaningDone = make(chan struct{})
-)
+const autoBanScore = 50
 
-func onSiteVisit(ip string, auth bool) *user {
-	rwlock.RLock()
-	usr, ok := users[ip]
-	rwlock.RUnlock()
-	if !ok {
-		return onFreshVisit(ip, auth)
-	}
+// don't "trust" initial requests under a distrusting policy as much
+const firstDistrustScore = 2
 
-	if auth {
-		usr.ulock.Lock()
+// buckets untouched this long are forgotten
+const bucketIdle = 5 * time.Minute
 
-		usr.behaviorScore++
-		if usr.behaviorScore%10 == 0 { // we should only sometimes cause distrust to decrease level
-			timeframe := time.Hour
-			trust := time.Duration(float32(10) * float32(1/usr.behaviorScore))
-			if trust == 0 {
-				timeframe /= 12
-				trust = 1
-			}
-			limiter := rate.NewLimiter(rate.Every(timeframe/trust), 5)
-			log.Printf("Changed trust threshhold for %s new rate %s with trust of %d\n", ip, time.Second/trust, trust)
-			usr.limiter = limiter
-		}
-		usr.ulock.Unlock()
+// onSiteVisit counts a request by client under a distrusting policy against its behavior
+// score, the new score is returned
+func onSiteVisit(limiter Limiter, client string, policy *LimitPolicy, now time.Time) (float64, error) {
+	score, err := limiter.AddScore(client, 1, firstDistrustScore, now)
+	if err != nil {
+		return 0, err
+	}
+
+	// we should only sometimes cause distrust to decrease level
+	step := float64(policy.distrustStep())
+	if level := math.Floor(score / step); level >= 1 && level > math.Floor((score-1)/step) {
+		log.Printf("Changed trust threshhold for %s new rate %.4f/s under %s\n", client, float64(policy.limit(score)), policy.Name)
 	}
 
-	return usr
+	return score, nil
 }
 
-func onFreshVisit(ip string, auth bool) *user {
-	var limiter *rate.Limiter
-	behavior := 0
-	if auth {
-		// don't "trust" initial auth requests as much
-		limiter = rate.NewLimiter(rate.Every(time.Second/5), 5)
-		behavior += 2
-	} else {
-		limiter = rate.NewLimiter(rate.Every(time.Second/10), 5)
-	}
-	user := &user{limiter: limiter, lastRequest: time.Now(), behaviorScore: behavior}
-	rwlock.Lock()
-	users[ip] = user
-	rwlock.Unlock()
-	return user
+// penalize adds points to the behavior score of client, the new score is returned
+func penalize(limiter Limiter, client string, points int, now time.Time) (float64, error) {
+	return limiter.AddScore(client, float64(points), float64(points), now)
 }
 
-func cleanLimiters() {
+// cleanLimiters forgets idle buckets and decayed scores until ctx is done
+func cleanLimiters(ctx context.Context, limiter Limiter) {
 	for {
 		select {
 		case <-time.After(1 * time.Minute):
-			rwlock.Lock()
-			for ip, usr := range users {
-				usr.ulock.Lock()
-				usr.behaviorScore = int(math.Max(float64(usr.behaviorScore-5), 0))
-				usr.ulock.Unlock()
-				if time.Since(usr.lastRequest) >= 5*time.Minute {
-					delete(users, ip)
-				}
+			if err := limiter.Prune(time.Now().Add(-bucketIdle)); err != nil {
+				log.Println(err)
 			}
-			rwlock.Unlock()
-		case <-cleaningDone:
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -0,0 +1,48 @@
+package httpserv
+
+import "net/http"
+
+type Middleware func(http.Handler) http.Handler
+
+// Router layers middleware over an http.ServeMux. The mux matches methods and path
+// wildcards, answering 404 for unknown paths and 405 with an Allow header for
+// known paths requested with the wrong method
+type Router struct {
+	mux        *http.ServeMux
+	middleware []Middleware
+	handler    http.Handler
+}
+
+func NewRouter() *Router {
+	mux := http.NewServeMux()
+	return &Router{mux: mux, handler: mux}
+}
+
+// Use adds middleware run for every request, including unmatched ones. Middleware
+// runs in the order it was added
+func (router *Router) Use(middleware ...Middleware) {
+	router.middleware = append(router.middleware, middleware...)
+	router.handler = chain(router.mux, router.middleware...)
+}
+
+// Handle registers handler for method and pattern, the route middleware only runs
+// once the route matched
+func (router *Router) Handle(method string, pattern string, handler http.Handler, middleware ...Middleware) {
+	router.mux.Handle(method+" "+pattern, chain(handler, middleware...))
+}
+
+func (router *Router) HandleFunc(method string, pattern string, handler http.HandlerFunc, middleware ...Middleware) {
+	router.Handle(method, pattern, handler, middleware...)
+}
+
+func (router *Router) ServeHTTP(rspn http.ResponseWriter, rqst *http.Request) {
+	router.handler.ServeHTTP(rspn, rqst)
+}
+
+func chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
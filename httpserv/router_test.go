@@ -0,0 +1,55 @@
+package httpserv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(rspn, rqst)
+			})
+		}
+	}
+
+	router := NewRouter()
+	router.Use(recovery, trace("global"))
+	router.HandleFunc(http.MethodGet, "/api/v1/photos/{id}", func(rspn http.ResponseWriter, rqst *http.Request) {
+		order = append(order, "handler")
+		rspn.Write([]byte(rqst.PathValue("id")))
+	}, trace("route"))
+	router.HandleFunc(http.MethodDelete, "/api/v1/photos/{id}", func(http.ResponseWriter, *http.Request) {})
+	router.HandleFunc(http.MethodGet, "/api/v1/panic", func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+
+	rspn := httptest.NewRecorder()
+	router.ServeHTTP(rspn, httptest.NewRequest(http.MethodGet, "/api/v1/photos/cat", nil))
+	if rspn.Body.String() != "cat" || strings.Join(order, ",") != "global,route,handler" {
+		t.Errorf("Expected body cat through global,route,handler, but got %s through %v", rspn.Body.String(), order)
+	}
+
+	rspn = httptest.NewRecorder()
+	router.ServeHTTP(rspn, httptest.NewRequest(http.MethodPut, "/api/v1/photos/cat", nil))
+	if rspn.Code != http.StatusMethodNotAllowed || rspn.Header().Get("Allow") != "DELETE, GET, HEAD" {
+		t.Errorf("Expected 405 allowing DELETE, GET, HEAD, but got %d %q", rspn.Code, rspn.Header().Get("Allow"))
+	}
+
+	rspn = httptest.NewRecorder()
+	router.ServeHTTP(rspn, httptest.NewRequest(http.MethodGet, "/api/v1/", nil))
+	if rspn.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for /api/v1/, but got %d", rspn.Code)
+	}
+
+	rspn = httptest.NewRecorder()
+	router.ServeHTTP(rspn, httptest.NewRequest(http.MethodGet, "/api/v1/panic", nil))
+	if rspn.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a recovered 500, but got %d", rspn.Code)
+	}
+}
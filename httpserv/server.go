@@ -1,84 +1,262 @@
 package httpserv
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"net"
 	"net/http"
-	"strings"
+	"net/netip"
+	"sync"
+	"time"
 
 	"github.com/Y2Kwastaken/gdn/rest"
 )
 
-var (
-	endpoint_handlers = make(map[string]func(*FileStore, string, http.ResponseWriter, *http.Request))
-)
+// ErrShutdownTimeout is returned when requests were still running once the
+// ShutdownTimeout passed, they are cut off
+var ErrShutdownTimeout = errors.New("requests still running after the shutdown timeout")
 
-func registerEndpoints() {
-	endpoint_handlers["photos"] = rest.PhotoEndpoints
-	endpoint_handlers["auth"] = rest.AuthEndpoints
+// DefaultTimeouts leave enough time to upload and download the largest images on a slow
+// connection, while slow clients still can't hold connections open forever
+var DefaultTimeouts = Timeouts{
+	ReadHeader: 10 * time.Second,
+	Read:       5 * time.Minute,
+	Write:      5 * time.Minute,
+	Idle:       2 * time.Minute,
+	Shutdown:   30 * time.Second,
 }
 
-func SetupHttpServer(store *FileStore) {
-	go cleanLimiters()
+// Timeouts of the HTTP server, see http.Server. Shutdown is how long in-flight
+// requests get to finish once the server is stopped
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Shutdown   time.Duration
+}
 
-	http.Handle("/", http.FileServer(http.Dir("./resources/assets/public")))
-	http.HandleFunc("/api/v1/", func(response http.ResponseWriter, request *http.Request) {
-		handlePathing(store, strings.ReplaceAll(request.URL.String(), "/api/v1/", ""), response, request)
-	})
+// Options tunes the HTTP server, the zero value listens on :8080 with the
+// DefaultTimeouts, trusts no proxies and uses the DefaultLimitPolicies with a
+// MemoryLimiter and the DefaultAggregation
+type Options struct {
+	// listen address, :8080 when empty
+	Address string
+	// directory of the static site
+	PublicDir string
+	// zero fields use the DefaultTimeouts
+	Timeouts Timeouts
+	TLS      TLSOptions
+	// client addresses are taken from forwarding headers only on connections from these
+	TrustedProxies []netip.Prefix
+	// the one forwarding header the trusted proxies write, DefaultClientIPHeader if empty
+	ClientIPHeader string
+	LimitPolicies  []LimitPolicy
+	// instances sharing a Limiter share their clients' allowance
+	Limiter     Limiter
+	Aggregation Aggregation
+}
 
-	registerEndpoints()
+func (options Options) withDefaults() Options {
+	if options.Address == "" {
+		options.Address = ":8080"
+	}
 
-	log.Println("GDN open on http://localhost:8080")
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
-		log.Println(err)
+	if options.PublicDir == "" {
+		options.PublicDir = "./resources/assets/public"
+	}
+
+	timeouts := []struct{ value, fallback *time.Duration }{
+		{&options.Timeouts.ReadHeader, &DefaultTimeouts.ReadHeader},
+		{&options.Timeouts.Read, &DefaultTimeouts.Read},
+		{&options.Timeouts.Write, &DefaultTimeouts.Write},
+		{&options.Timeouts.Idle, &DefaultTimeouts.Idle},
+		{&options.Timeouts.Shutdown, &DefaultTimeouts.Shutdown},
+	}
+	for _, timeout := range timeouts {
+		if *timeout.value == 0 {
+			*timeout.value = *timeout.fallback
+		}
+	}
+
+	if len(options.LimitPolicies) == 0 {
+		options.LimitPolicies = DefaultLimitPolicies
+	}
+
+	if options.ClientIPHeader == "" {
+		options.ClientIPHeader = DefaultClientIPHeader
 	}
 
-	close(cleaningDone)
+	if options.Limiter == nil {
+		options.Limiter = NewMemoryLimiter()
+	}
+
+	if options.Aggregation.IPv4Bits == 0 {
+		options.Aggregation.IPv4Bits = DefaultAggregation.IPv4Bits
+	}
+
+	if options.Aggregation.IPv6Bits == 0 {
+		options.Aggregation.IPv6Bits = DefaultAggregation.IPv6Bits
+	}
+
+	return options
 }
 
-func handlePathing(store *FileStore, urlPart string, rsp http.ResponseWriter, rqst *http.Request) {
-	ip, _, err := net.SplitHostPort(rqst.RemoteAddr)
-	if err != nil {
-		rsp.WriteHeader(http.StatusInternalServerError)
-		return
+func registerEndpoints(router *Router, store *FileStore) {
+	for _, route := range rest.Routes() {
+		var middleware []Middleware
+		if route.Scope != "" {
+			middleware = append(middleware, requireScope(store, route.Scope))
+		}
+
+		handler := route.Handler
+		router.HandleFunc(route.Method, route.Pattern, func(rspn http.ResponseWriter, rqst *http.Request) {
+			handler(store, rspn, rqst)
+		}, middleware...)
 	}
+}
 
-	auth := strings.Contains(urlPart, "auth")
-	usr := onSiteVisit(ip, auth)
-	usr.ulock.RLock()
-	if usr.behaviorScore >= 50 {
-		usr.ulock.RUnlock()
-		http.Error(rsp, "Temporarily Banned", http.StatusForbidden)
-		return
+func newHandler(store *FileStore, options Options) http.Handler {
+	api := NewRouter()
+	api.Use(rateLimit(store, options), authGuard(store, options))
+	registerEndpoints(api, store)
+
+	root := http.NewServeMux()
+	root.Handle("/", http.FileServer(http.Dir(options.PublicDir)))
+	root.Handle("/api/", api)
+
+	middleware := []Middleware{realIP(options.TrustedProxies, options.ClientIPHeader), recovery, accessLog}
+	if options.TLS.Enabled() && options.TLS.HSTSMaxAge > 0 {
+		middleware = append(middleware, hsts(options.TLS.HSTSMaxAge))
 	}
-	if !usr.limiter.Allow() {
-		usr.ulock.RUnlock()
-		http.Error(rsp, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
-		return
+
+	return chain(root, middleware...)
+}
+
+// expired bans are kept this long so administrators can see what happened
+const banHistory = 30 * 24 * time.Hour
+
+// pruneAuth clears out expired sessions, stale lockouts and old bans until ctx is done
+func pruneAuth(ctx context.Context, store *FileStore) {
+	for {
+		select {
+		case <-time.After(time.Hour):
+			if _, err := store.Database.PruneSessions(); err != nil {
+				log.Println(err)
+			}
+			store.Lockouts.Prune()
+			if _, err := store.Database.PruneBans(time.Now().Add(-banHistory)); err != nil {
+				log.Println(err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newServer(address string, handler http.Handler, timeouts Timeouts) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		ErrorLog:          log.Default(),
 	}
-	usr.ulock.RUnlock()
+}
+
+// SetupHttpServer serves until ctx is done, then stops accepting connections and gives
+// in-flight requests the shutdown timeout to finish. Background cleanup has stopped by
+// the time it returns. nil is returned after a clean shutdown, ErrShutdownTimeout if requests had
+// to be cut off and the listener's error if the server couldn't run at all
+func SetupHttpServer(ctx context.Context, store *FileStore, options Options) error {
+	options = options.withDefaults()
+	server := newServer(options.Address, newHandler(store, options), options.Timeouts)
+
+	// background work has returned before this does, so the store can be closed after
+	background, stop := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	defer func() {
+		stop()
+		running.Wait()
+	}()
 
-	pathParts := strings.Split(strings.Trim(rqst.URL.Path, "/"), "/")
-	if len(pathParts) == 0 {
-		http.Error(rsp, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return
+	servers := []*http.Server{server}
+	if options.TLS.Enabled() {
+		reloader, err := NewCertReloader(options.TLS.CertFile, options.TLS.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+
+		server.TLSConfig = newTLSConfig(reloader)
+		running.Go(func() { reloader.watch(background) })
+
+		if options.TLS.RedirectAddress != "" {
+			servers = append(servers, newServer(options.TLS.RedirectAddress, redirectHTTPS(options.Address), options.Timeouts))
+		}
 	}
 
-	resource := pathParts[2] // 0 api 1 v1 2 [resource]
+	running.Go(func() { cleanLimiters(background, options.Limiter) })
+	running.Go(func() { pruneAuth(background, store) })
+
+	served := make(chan error, len(servers))
+	go func() {
+		if server.TLSConfig != nil {
+			log.Println("GDN open on", options.Address, "with TLS")
+			served <- server.ListenAndServeTLS("", "")
+			return
+		}
 
-	result, ok := endpoint_handlers[resource]
-	if !ok {
-		http.Error(rsp, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
-		return
+		log.Println("GDN open on", options.Address)
+		served <- server.ListenAndServe()
+	}()
+
+	for _, redirect := range servers[1:] {
+		go func() {
+			log.Println("Redirecting HTTP on", redirect.Addr, "to HTTPS")
+			served <- redirect.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-served:
+		// one listener failing takes the others down with it
+		for _, other := range servers {
+			other.Close()
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for requests to finish\n", options.Timeouts.Shutdown)
+	drain, cancel := context.WithTimeout(context.Background(), options.Timeouts.Shutdown)
+	defer cancel()
+
+	var err error
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(drain); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		for _, server := range servers {
+			server.Close()
+		}
+		return ErrShutdownTimeout
+	}
+
+	if err != nil {
+		return fmt.Errorf("shutdown: %w", err)
 	}
 
-	var remainderPath string
-	if len(pathParts) > 1 {
-		remainderPath = strings.Join(pathParts[3:], "/")
-	} else {
-		remainderPath = ""
+	for range servers {
+		if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
 	}
 
-	result(store, remainderPath, rsp, rqst)
+	return nil
 }
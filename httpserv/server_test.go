@@ -0,0 +1,83 @@
+package httpserv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+// startServer runs SetupHttpServer on a free port until the returned cancel is called,
+// the server's result arrives on the channel
+func startServer(t *testing.T, shutdown time.Duration) (string, context.CancelFunc, <-chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store, done := internal.NewTestStore(t), make(chan error, 1)
+	options := Options{Address: address, PublicDir: t.TempDir(), Timeouts: Timeouts{Shutdown: shutdown}}
+	go func() { done <- SetupHttpServer(ctx, store, options) }()
+
+	for range 50 {
+		rspn, err := http.Get("http://" + address + "/api/v1/photos")
+		if err == nil {
+			rspn.Body.Close()
+			return address, cancel, done
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatal("server never came up")
+	return "", nil, nil
+}
+
+func waitServer(t *testing.T, done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop")
+		return nil
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	_, cancel, done := startServer(t, time.Second)
+	cancel()
+
+	if err := waitServer(t, done); err != nil {
+		t.Fatalf("Expected a clean shutdown, but got %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	t.Setenv("ADMIN_SECRET", "very_secure_secret")
+	address, cancel, done := startServer(t, 200*time.Millisecond)
+
+	// an upload whose body never finishes keeps its request in flight
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "PUT /api/v1/photos HTTP/1.1\r\nHost: %s\r\nX-API-Key: very_secure_secret\r\n", address)
+	fmt.Fprint(conn, "Content-Type: multipart/form-data; boundary=x\r\nContent-Length: 100000\r\n\r\n--x\r\n")
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	if err := waitServer(t, done); !errors.Is(err, ErrShutdownTimeout) {
+		t.Fatalf("Expected %v, but got %v", ErrShutdownTimeout, err)
+	}
+}
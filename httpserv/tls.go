@@ -0,0 +1,161 @@
+package httpserv
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how often the certificate files are checked for changes, e.g. after a renewal
+const certPollInterval = time.Minute
+
+// TLSOptions turns on HTTPS, TLS is off while CertFile is empty
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// plain HTTP listener redirecting every request to HTTPS, none when empty
+	RedirectAddress string
+	// Strict-Transport-Security max-age, zero sends no header
+	HSTSMaxAge time.Duration
+}
+
+func (options TLSOptions) Enabled() bool {
+	return options.CertFile != ""
+}
+
+// CertReloader serves a certificate pair which is reloaded on SIGHUP and whenever the
+// files change, a pair which fails to load leaves the current one in place
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	lock     sync.RWMutex
+}
+
+func NewCertReloader(certFile string, keyFile string) (*CertReloader, error) {
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (reloader *CertReloader) Reload() error {
+	modTime := reloader.lastModified()
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+
+	reloader.lock.Lock()
+	reloader.cert, reloader.modTime = &cert, modTime
+	reloader.lock.Unlock()
+	return nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (reloader *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.lock.RLock()
+	defer reloader.lock.RUnlock()
+	return reloader.cert, nil
+}
+
+// lastModified is the newest modification time of the pair
+func (reloader *CertReloader) lastModified() time.Time {
+	var latest time.Time
+	for _, file := range []string{reloader.certFile, reloader.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (reloader *CertReloader) changed() bool {
+	reloader.lock.RLock()
+	defer reloader.lock.RUnlock()
+	return !reloader.lastModified().Equal(reloader.modTime)
+}
+
+// watch reloads the pair on SIGHUP or when the files change until ctx is done
+func (reloader *CertReloader) watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(certPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hangup:
+		case <-ticker.C:
+			if !reloader.changed() {
+				continue
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		// a renewal may write the certificate and key separately, the next tick retries
+		if err := reloader.Reload(); err != nil {
+			log.Println("Unable to reload the TLS certificate, keeping the current one", err)
+			continue
+		}
+		log.Println("Reloaded the TLS certificate")
+	}
+}
+
+func newTLSConfig(reloader *CertReloader) *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: reloader.GetCertificate}
+}
+
+// hsts tells browsers to only use HTTPS for maxAge, it is only sent over TLS as
+// browsers ignore it otherwise
+func hsts(maxAge time.Duration) Middleware {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+			if rqst.TLS != nil {
+				rspn.Header().Set("Strict-Transport-Security", value)
+			}
+			next.ServeHTTP(rspn, rqst)
+		})
+	}
+}
+
+// redirectHTTPS sends every request to the same URL on the HTTPS address
+func redirectHTTPS(httpsAddress string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddress)
+	return http.HandlerFunc(func(rspn http.ResponseWriter, rqst *http.Request) {
+		host := rqst.Host
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		host = strings.Trim(host, "[]")
+
+		if host == "" {
+			http.Error(rspn, "Host header required", http.StatusBadRequest)
+			return
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		http.Redirect(rspn, rqst, "https://"+host+rqst.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,148 @@
+package httpserv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for localhost with the given serial
+func writeCert(t *testing.T, certFile string, keyFile string, serial int64) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serial(t *testing.T, reloader *CertReloader) int64 {
+	t.Helper()
+	cert, _ := reloader.GetCertificate(&tls.ClientHelloInfo{})
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloader.changed() {
+		t.Error("Expected no change right after loading")
+	}
+
+	writeCert(t, certFile, keyFile, 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	if !reloader.changed() {
+		t.Error("Expected the rewritten files to count as changed")
+	}
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := serial(t, reloader); got != 2 {
+		t.Errorf("Expected serial 2 after reloading, but got %d", got)
+	}
+
+	// a half written renewal keeps the old certificate
+	os.WriteFile(keyFile, []byte("not a key"), 0o600)
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected a broken key to fail reloading")
+	}
+
+	if got := serial(t, reloader); got != 2 {
+		t.Errorf("Expected serial 2 to be kept, but got %d", got)
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		address  string
+		host     string
+		target   string
+		expected string
+	}{
+		{":443", "example.com", "/api/v1/photos?page=2", "https://example.com/api/v1/photos?page=2"},
+		{":443", "example.com:80", "/", "https://example.com/"},
+		{":8443", "example.com:8080", "/login.html", "https://example.com:8443/login.html"},
+		{":443", "[2001:db8::1]:80", "/", "https://[2001:db8::1]/"},
+		{":8443", "[2001:db8::1]", "/", "https://[2001:db8::1]:8443/"},
+	}
+
+	for _, test := range tests {
+		rqst := httptest.NewRequest(http.MethodGet, test.target, nil)
+		rqst.Host = test.host
+		rspn := httptest.NewRecorder()
+		redirectHTTPS(test.address).ServeHTTP(rspn, rqst)
+
+		if rspn.Code != http.StatusPermanentRedirect || rspn.Header().Get("Location") != test.expected {
+			t.Errorf("%s%s on %s: expected %s, but got %d %s", test.host, test.target, test.address, test.expected, rspn.Code, rspn.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	handler := hsts(365 * 24 * time.Hour)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	rqst := httptest.NewRequest(http.MethodGet, "/", nil)
+	rspn := httptest.NewRecorder()
+	handler.ServeHTTP(rspn, rqst)
+	if got := rspn.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Expected no HSTS over plain HTTP, but got %q", got)
+	}
+
+	rqst.TLS = &tls.ConnectionState{}
+	rspn = httptest.NewRecorder()
+	handler.ServeHTTP(rspn, rqst)
+	if got := rspn.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("Expected max-age=31536000, but got %q", got)
+	}
+}
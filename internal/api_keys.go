@@ -0,0 +1,307 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	ScopePhotosWrite  = "photos:write"
+	ScopePhotosDelete = "photos:delete"
+	ScopeAdmin        = "admin"
+)
+
+var Scopes = []string{ScopePhotosWrite, ScopePhotosDelete, ScopeAdmin}
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyExists   = errors.New("a live api key with this name already exists")
+	// wraps invalid names and scopes given to CreateAPIKey
+	ErrAPIKeyInvalid = errors.New("invalid api key")
+)
+
+// keys look like gdn_<id>_<secret>, the id is stored in the clear for lookup while
+// only the sha256 of the secret is kept
+const apiKeyPrefix = "gdn_"
+
+// last_used_at is only rewritten once per window so verification isn't a write per request
+const lastUsedWindow = time.Minute
+
+// the bootstrap key configured through ADMIN_SECRET
+const RootKeyName = "ADMIN_SECRET"
+
+// the root key grants everything, so it must be long and not a well known placeholder
+const minRootSecret = 16
+
+var placeholderSecrets = []string{"admin", "password", "secret", "changeme", "change_me", "admin_secret", "very_secure_secret"}
+
+// CheckRootSecret refuses an empty, short or placeholder ADMIN_SECRET
+func CheckRootSecret() error {
+	root := os.Getenv(RootKeyName)
+	if root == "" {
+		return errors.New("ADMIN_SECRET is not set, set it or ADMIN_SECRET_FILE")
+	}
+
+	if slices.Contains(placeholderSecrets, strings.ToLower(root)) {
+		return errors.New("ADMIN_SECRET is a default value, generate a random secret")
+	}
+
+	if len(root) < minRootSecret {
+		return fmt.Errorf("ADMIN_SECRET must be at least %d characters", minRootSecret)
+	}
+
+	return nil
+}
+
+type apiKeyContext struct{}
+
+func WithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContext{}, key)
+}
+
+// APIKeyFrom returns the key which authenticated the request, if any
+func APIKeyFrom(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContext{}).(*APIKey)
+	return key
+}
+
+// HasScope reports whether the key grants scope, admin grants everything
+func (key *APIKey) HasScope(scope string) bool {
+	return slices.Contains(key.Scopes, ScopeAdmin) || slices.Contains(key.Scopes, scope)
+}
+
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+
+	return nil
+}
+
+// CreateAPIKey stores a new key and returns it with its secret, the secret can't be
+// recovered afterwards
+func (db *Database) CreateAPIKey(name string, scopes []string) (*APIKey, string, error) {
+	if strings.TrimSpace(name) == "" || name == RootKeyName {
+		return nil, "", fmt.Errorf("%w: name %q", ErrAPIKeyInvalid, name)
+	}
+
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrAPIKeyInvalid, err)
+	}
+
+	id, secret, hash, err := newAPIKeySecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	query := `INSERT INTO api_keys (id, name, hash, scopes, created_at) VALUES ( ?, ?, ?, ?, ? )`
+	_, err = db.conn.Exec(query, id, name, hash, strings.Join(scopes, " "), now.Unix())
+	if uniqueViolation(err) {
+		return nil, "", ErrAPIKeyExists
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &APIKey{Id: id, Name: name, Scopes: scopes, CreatedAt: now.UTC().Truncate(time.Second)}
+	return key, secret, nil
+}
+
+// RotateAPIKey swaps the secret of a live key, the old secret and any sessions made
+// with it stop working immediately
+func (db *Database) RotateAPIKey(name string) (*APIKey, string, error) {
+	id, secret, hash, err := newAPIKeySecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `UPDATE api_keys SET id = ?, hash = ?, created_at = ?, last_used_at = 0 WHERE name = ? AND revoked_at = 0`
+	rslt, err := db.conn.Exec(query, id, hash, time.Now().Unix(), name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if rows, err := rslt.RowsAffected(); err != nil || rows == 0 {
+		return nil, "", ErrAPIKeyNotFound
+	}
+
+	if err := db.DeleteSessionsFor(name); err != nil {
+		return nil, "", err
+	}
+
+	key, _, err := db.queryAPIKey(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, secret, nil
+}
+
+func (db *Database) RevokeAPIKey(name string) error {
+	query := `UPDATE api_keys SET revoked_at = ? WHERE name = ? AND revoked_at = 0`
+	rslt, err := db.conn.Exec(query, time.Now().Unix(), name)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := rslt.RowsAffected(); err != nil || rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return db.DeleteSessionsFor(name)
+}
+
+func (db *Database) ListAPIKeys() ([]APIKey, error) {
+	rows, err := db.conn.Query(`SELECT id, name, scopes, created_at, last_used_at, revoked_at FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Authenticate resolves a presented key to the APIKey it belongs to, returning nil for
+// unknown, malformed or revoked keys. ADMIN_SECRET is accepted as a root admin key
+func (db *Database) Authenticate(presented string) (*APIKey, error) {
+	if presented == "" {
+		return nil, nil
+	}
+
+	root := os.Getenv("ADMIN_SECRET")
+	if root != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(root)) == 1 {
+		return &APIKey{Id: "root", Name: RootKeyName, Scopes: []string{ScopeAdmin}}, nil
+	}
+
+	id, secret, ok := parseAPIKey(presented)
+	if !ok {
+		return nil, nil
+	}
+
+	key, hash, err := db.queryAPIKey(`WHERE id = ? AND revoked_at = 0`, id)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		// compare anyway so unknown ids take as long as wrong secrets
+		sum := sha256.Sum256([]byte(secret))
+		subtle.ConstantTimeCompare(sum[:], make([]byte, sha256.Size))
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(sum[:], hash) != 1 {
+		return nil, nil
+	}
+
+	if time.Since(key.LastUsedAt) >= lastUsedWindow {
+		now := time.Now()
+		if _, err := db.conn.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now.Unix(), id); err != nil {
+			return nil, err
+		}
+		key.LastUsedAt = now.UTC().Truncate(time.Second)
+	}
+
+	return key, nil
+}
+
+// queryAPIKey loads a single key along with its stored hash
+func (db *Database) queryAPIKey(where string, arg any) (*APIKey, []byte, error) {
+	query := `SELECT id, name, scopes, created_at, last_used_at, revoked_at, hash FROM api_keys ` + where
+	row := db.conn.QueryRow(query, arg)
+
+	var hash []byte
+	key, err := scanAPIKey(row, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, hash, nil
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }, extra ...any) (*APIKey, error) {
+	key := &APIKey{}
+	var scopes string
+	var createdAt, lastUsedAt, revokedAt int64
+
+	dest := append([]any{&key.Id, &key.Name, &scopes, &createdAt, &lastUsedAt, &revokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	key.CreatedAt = unixOrZero(createdAt)
+	key.LastUsedAt = unixOrZero(lastUsedAt)
+	key.RevokedAt = unixOrZero(revokedAt)
+	return key, nil
+}
+
+// uniqueViolation reports whether err comes from a UNIQUE index, key ids colliding
+// violate the primary key instead and stay plain errors
+func uniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+func newAPIKeySecret() (id string, key string, hash []byte, err error) {
+	idBytes := make([]byte, 6)
+	if _, err = rand.Read(idBytes); err != nil {
+		return "", "", nil, err
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err = rand.Read(secretBytes); err != nil {
+		return "", "", nil, err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	sum := sha256.Sum256([]byte(secret))
+	return id, apiKeyPrefix + id + "_" + secret, sum[:], nil
+}
+
+func parseAPIKey(key string) (id string, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db := NewTestStore(t).Database
+	t.Setenv("ADMIN_SECRET", "")
+
+	key, secret, err := db.CreateAPIKey("uploader", []string{ScopePhotosWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := db.CreateAPIKey("uploader", []string{ScopePhotosWrite}); !errors.Is(err, ErrAPIKeyExists) {
+		t.Errorf("Expected duplicate live key name to be rejected, but got %v", err)
+	}
+
+	if _, _, err := db.CreateAPIKey("bad", []string{"photos:read"}); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("Expected unknown scope to be rejected, but got %v", err)
+	}
+
+	if _, _, err := db.CreateAPIKey(" ", []string{ScopePhotosWrite}); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Errorf("Expected blank name to be rejected, but got %v", err)
+	}
+
+	found, err := db.Authenticate(secret)
+	if err != nil || found == nil || found.Id != key.Id || found.LastUsedAt.IsZero() {
+		t.Fatalf("Expected %s to authenticate, but got %v %v", key.Name, found, err)
+	}
+
+	if !found.HasScope(ScopePhotosWrite) || found.HasScope(ScopePhotosDelete) {
+		t.Errorf("Expected only the photos:write scope, but got %v", found.Scopes)
+	}
+
+	for _, invalid := range []string{"", secret + "x", "gdn_" + key.Id + "_", "gdn_000000000000_abc", "not a key"} {
+		if found, err := db.Authenticate(invalid); err != nil || found != nil {
+			t.Errorf("Expected %q to be rejected, but got %v %v", invalid, found, err)
+		}
+	}
+
+	_, rotated, err := db.RotateAPIKey("uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := db.Authenticate(secret); found != nil {
+		t.Errorf("Expected the pre-rotation secret to stop working")
+	}
+
+	if found, _ := db.Authenticate(rotated); found == nil || found.Name != "uploader" {
+		t.Errorf("Expected the rotated secret to authenticate, but got %v", found)
+	}
+
+	if err := db.RevokeAPIKey("uploader"); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := db.Authenticate(rotated); found != nil {
+		t.Errorf("Expected a revoked key to be rejected")
+	}
+
+	if err := db.RevokeAPIKey("uploader"); err != ErrAPIKeyNotFound {
+		t.Errorf("Expected ErrAPIKeyNotFound revoking twice, but got %v", err)
+	}
+
+	keys, err := db.ListAPIKeys()
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt.IsZero() {
+		t.Errorf("Expected the revoked key to be listed, but got %v %v", keys, err)
+	}
+
+	t.Setenv("ADMIN_SECRET", "very_secure_secret")
+	if found, _ := db.Authenticate("very_secure_secret"); found == nil || !found.HasScope(ScopePhotosDelete) {
+		t.Errorf("Expected ADMIN_SECRET to act as an admin key, but got %v", found)
+	}
+}
+
+func TestCheckRootSecret(t *testing.T) {
+	for secret, ok := range map[string]bool{
+		"":                                 false,
+		"password":                         false,
+		"Very_Secure_Secret":               false,
+		"short-but-random":                 true,
+		"tooshort":                         false,
+		"p7Jw0QmZr2xK9bN4sL6vT1yH8uE3cA5d": true,
+	} {
+		t.Setenv("ADMIN_SECRET", secret)
+		if err := CheckRootSecret(); (err == nil) != ok {
+			t.Errorf("CheckRootSecret with %q: %v", secret, err)
+		}
+	}
+}
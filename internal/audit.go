@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Audit logs a security relevant event as a single [AUDIT] line, fields alternate
+// between names and values
+func Audit(event string, fields ...any) {
+	var line strings.Builder
+	line.WriteString("[AUDIT] ")
+	line.WriteString(event)
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fmt.Sprint(fields[i+1])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %v=%s", fields[i], value)
+	}
+
+	log.Println(line.String())
+}
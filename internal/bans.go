@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+var ErrBanNotFound = errors.New("ban not found")
+
+const banCacheTTL = time.Minute
+
+// ParsePrefix accepts a single address or a CIDR range, addresses become a prefix
+// covering only themselves
+func ParsePrefix(target string) (netip.Prefix, error) {
+	target = strings.TrimSpace(target)
+	if strings.Contains(target, "/") {
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// AddBan bans cidr until expires, a zero expires never expires
+func (db *Database) AddBan(cidr netip.Prefix, reason string, origin string, by string, expires time.Time) (*Ban, error) {
+	if origin != BanAutomatic && origin != BanManual {
+		return nil, fmt.Errorf("invalid ban origin %q", origin)
+	}
+
+	now := time.Now()
+	ban := &Ban{CIDR: cidr.Masked(), Reason: reason, Origin: origin, CreatedBy: by, CreatedAt: now.UTC().Truncate(time.Second)}
+	if !expires.IsZero() {
+		ban.ExpiresAt = expires.UTC().Truncate(time.Second)
+	}
+
+	query := `INSERT INTO bans (cidr, reason, origin, created_by, created_at, expires_at) VALUES ( ?, ?, ?, ?, ?, ? )`
+	rslt, err := db.conn.Exec(query, ban.CIDR.String(), reason, origin, by, ban.CreatedAt.Unix(), unixOrZeroTime(ban.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+
+	if ban.Id, err = rslt.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	db.bans.invalidate()
+	return ban, nil
+}
+
+func (db *Database) DeleteBan(id int64) error {
+	rslt, err := db.conn.Exec(`DELETE FROM bans WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	if rows, err := rslt.RowsAffected(); err != nil || rows == 0 {
+		return ErrBanNotFound
+	}
+
+	db.bans.invalidate()
+	return nil
+}
+
+// ListBans returns the bans in the order they were made, expired ones only when asked
+func (db *Database) ListBans(expired bool) ([]Ban, error) {
+	query := `SELECT id, cidr, reason, origin, created_by, created_at, expires_at FROM bans`
+	args := []any{}
+	if !expired {
+		query += ` WHERE expires_at = 0 OR expires_at > ?`
+		args = append(args, time.Now().Unix())
+	}
+
+	rows, err := db.conn.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []Ban{}
+	for rows.Next() {
+		var ban Ban
+		var cidr string
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&ban.Id, &cidr, &ban.Reason, &ban.Origin, &ban.CreatedBy, &createdAt, &expiresAt); err != nil {
+			return nil, err
+		}
+
+		if ban.CIDR, err = netip.ParsePrefix(cidr); err != nil {
+			return nil, err
+		}
+
+		ban.CreatedAt = time.Unix(createdAt, 0).UTC()
+		ban.ExpiresAt = unixOrZero(expiresAt)
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+// PruneBans removes bans which expired before cutoff
+func (db *Database) PruneBans(cutoff time.Time) (int64, error) {
+	rslt, err := db.conn.Exec(`DELETE FROM bans WHERE expires_at <> 0 AND expires_at <= ?`, cutoff.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	db.bans.invalidate()
+	return rslt.RowsAffected()
+}
+
+// Banned returns the live ban covering addr, the ban expiring last when several do
+func (db *Database) Banned(addr netip.Addr) (*Ban, error) {
+	bans, err := db.liveBans()
+	if err != nil {
+		return nil, err
+	}
+
+	addr = addr.Unmap().WithZone("")
+	now := time.Now()
+
+	var match *Ban
+	for i := range bans {
+		ban := &bans[i]
+		if !ban.CIDR.Contains(addr) || (!ban.ExpiresAt.IsZero() && !now.Before(ban.ExpiresAt)) {
+			continue
+		}
+
+		if match == nil || ban.ExpiresAt.IsZero() || (!match.ExpiresAt.IsZero() && ban.ExpiresAt.After(match.ExpiresAt)) {
+			match = ban
+		}
+	}
+
+	if match == nil {
+		return nil, nil
+	}
+
+	found := *match
+	return &found, nil
+}
+
+func (db *Database) liveBans() ([]Ban, error) {
+	cache := db.bans
+	cache.lock.RLock()
+	if time.Since(cache.loadedAt) < banCacheTTL {
+		defer cache.lock.RUnlock()
+		return cache.bans, nil
+	}
+	version := cache.version
+	cache.lock.RUnlock()
+
+	bans, err := db.ListBans(false)
+	if err != nil {
+		return nil, err
+	}
+
+	// a change while loading leaves the cache stale so the next check loads again
+	cache.lock.Lock()
+	if cache.version == version {
+		cache.bans = bans
+		cache.loadedAt = time.Now()
+	}
+	cache.lock.Unlock()
+	return bans, nil
+}
+
+func (cache *banCache) invalidate() {
+	cache.lock.Lock()
+	cache.version++
+	cache.loadedAt = time.Time{}
+	cache.lock.Unlock()
+}
+
+func unixOrZeroTime(date time.Time) int64 {
+	if date.IsZero() {
+		return 0
+	}
+	return date.Unix()
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.7":            "192.0.2.7/32",
+		"192.0.2.7/24":         "192.0.2.0/24",
+		"::ffff:192.0.2.7":     "192.0.2.7/32",
+		"::ffff:192.0.2.0/120": "192.0.2.0/24",
+		"2001:db8::1":          "2001:db8::1/128",
+		"2001:db8:1:2:3::/48":  "2001:db8:1::/48",
+		" 198.51.100.0/24 ":    "198.51.100.0/24",
+	}
+
+	for target, expected := range tests {
+		prefix, err := ParsePrefix(target)
+		if err != nil || prefix.String() != expected {
+			t.Errorf("Expected %q to parse as %s, but got %s %v", target, expected, prefix, err)
+		}
+	}
+
+	for _, target := range []string{"", "example.com", "192.0.2.7/33"} {
+		if _, err := ParsePrefix(target); err == nil {
+			t.Errorf("Expected %q to be rejected", target)
+		}
+	}
+}
+
+func TestBans(t *testing.T) {
+	db := NewTestStore(t).Database
+
+	rng, _ := ParsePrefix("192.0.2.0/24")
+	if _, err := db.AddBan(rng, "scanner", BanManual, "ADMIN_SECRET", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	single, _ := ParsePrefix("198.51.100.4")
+	temporary, err := db.AddBan(single, "score", BanAutomatic, "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"192.0.2.200":        true,
+		"::ffff:192.0.2.200": true,
+		"192.0.3.1":          false,
+		"198.51.100.4":       true,
+		"198.51.100.5":       false,
+	}
+
+	for ip, banned := range tests {
+		ban, err := db.Banned(netip.MustParseAddr(ip))
+		if err != nil || (ban != nil) != banned {
+			t.Errorf("Expected %s banned to be %v, but got %v %v", ip, banned, ban, err)
+		}
+	}
+
+	if _, err := db.conn.Exec(`UPDATE bans SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Second).Unix(), temporary.Id); err != nil {
+		t.Fatal(err)
+	}
+	db.bans.invalidate()
+
+	if ban, _ := db.Banned(netip.MustParseAddr("198.51.100.4")); ban != nil {
+		t.Errorf("Expected an expired ban to no longer apply, but got %v", ban)
+	}
+
+	if bans, _ := db.ListBans(false); len(bans) != 1 {
+		t.Errorf("Expected one live ban, but got %v", bans)
+	}
+
+	if bans, _ := db.ListBans(true); len(bans) != 2 {
+		t.Errorf("Expected two bans including expired ones, but got %v", bans)
+	}
+
+	if err := db.DeleteBan(temporary.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteBan(temporary.Id); err != ErrBanNotFound {
+		t.Errorf("Expected ErrBanNotFound, but got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+type BlobInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	ETag         string
+}
+
+// BlobStore is the object storage backend used by FileStore. Implementations
+// must return ErrBlobNotFound (or an error wrapping it) when a key is missing
+type BlobStore interface {
+	Put(ctx context.Context, bucket string, key string, reader io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, bucket string, key string) (io.ReadSeekCloser, *BlobInfo, error)
+	Stat(ctx context.Context, bucket string, key string) (*BlobInfo, error)
+	Delete(ctx context.Context, bucket string, key string) error
+	List(ctx context.Context, bucket string) ([]BlobInfo, error)
+}
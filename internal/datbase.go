@@ -4,87 +4,87 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	_ "modernc.org/sqlite"
 )
 
+// defaultPragmas are applied to every connection unless the DSN sets them. Writers, e.g.
+// instances sharing rate limits, wait for the lock instead of failing with SQLITE_BUSY
+// and WAL lets reads go on while one of them writes
+var defaultPragmas = []string{"busy_timeout(10000)", "journal_mode(WAL)"}
+
 func NewDBConnection(constr string) (*Database, error) {
-	conn, err := sql.Open("sqlite", constr)
+	conn, err := sql.Open("sqlite", withPragmas(constr))
 	if err != nil {
 		return nil, err
 	}
 
-	return &Database{conn: conn}, nil
+	return &Database{conn: conn, bans: &banCache{}}, nil
 }
 
-func (db *Database) SetupTables() error {
-	conn := db.conn
-	query := `CREATE TABLE IF NOT EXISTS image_meta (
-		id BLOB PRIMARY KEY,
-		image_name TEXT NOT NULL,
-		image_type TEXT NOT NULL,
-		description TEXT
-
-	)`
-
-	_, err := conn.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	query = `CREATE TABLE IF NOT EXISTS image_tags (
-		id BLOB,
-		tag TEXT
-	)`
+// withPragmas adds the defaultPragmas the DSN doesn't set itself
+func withPragmas(constr string) string {
+	for _, pragma := range defaultPragmas {
+		name, _, _ := strings.Cut(pragma, "(")
+		if strings.Contains(strings.ToLower(constr), "_pragma="+name) {
+			continue
+		}
 
-	_, err = conn.Exec(query)
-	if err != nil {
-		return err
+		separator := "?"
+		if strings.Contains(constr, "?") {
+			separator = "&"
+		}
+		constr += separator + "_pragma=" + pragma
 	}
 
-	return nil
+	return constr
 }
 
-func (db *Database) UploadImageMeta(metadata *Metadata) (*uuid.UUID, error) {
-	conn := db.conn
-	imageId, err := uuid.NewRandom()
-	if err != nil {
-		return nil, err
-	}
+func (db *Database) Close() error {
+	return db.conn.Close()
+}
 
-	query := `INSERT INTO image_meta (id , image_name, image_type, description) VALUES( ?, ?, ?, ? )`
+// BeginImageUpload inserts the metadata and tags of a new image in a single transaction
+// which is handed back uncommitted, the caller decides to commit or roll it back
+func (db *Database) BeginImageUpload(imageId uuid.UUID, metadata *Metadata) (*sql.Tx, error) {
 	imageIdBytes, err := imageId.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = conn.Exec(query, imageIdBytes, metadata.Title, metadata.ImageType, metadata.Description)
+	trsn, err := db.conn.Begin()
 	if err != nil {
 		return nil, err
 	}
 
-	trsn, err := conn.Begin()
+	now := time.Now().Unix()
+	query := `INSERT INTO image_meta (id , image_name, image_type, description, size, width, height, created_at, updated_at)
+		VALUES( ?, ?, ?, ?, ?, ?, ?, ?, ? )`
+	_, err = trsn.Exec(query, imageIdBytes, metadata.Title, metadata.ImageType, metadata.Description,
+		metadata.Size, metadata.Width, metadata.Height, now, now)
 	if err != nil {
+		trsn.Rollback()
 		return nil, err
 	}
 
 	query = `INSERT INTO image_tags (id, tag) VALUES ( ?, ? )`
-	for i := range metadata.Tags {
-		tag := metadata.Tags[i]
-		println(tag)
+	for _, tag := range metadata.Tags {
 		_, err = trsn.Exec(query, imageIdBytes, tag)
 		if err != nil {
+			trsn.Rollback()
 			return nil, err
 		}
 	}
 
-	err = trsn.Commit()
-	if err != nil {
+	if err = indexTags(trsn, imageIdBytes); err != nil {
+		trsn.Rollback()
 		return nil, err
 	}
 
-	return &imageId, nil
+	return trsn, nil
 }
 
 func (db *Database) DeleteImage(uuid uuid.UUID) error {
@@ -107,12 +107,19 @@ func (db *Database) DeleteImage(uuid uuid.UUID) error {
 		return err
 	}
 
+	query = `DELETE FROM image_derivatives where id = ?`
+	_, err = conn.Exec(query, uuidBytes)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (db *Database) QueryImage(inUUID uuid.UUID) (*ImageMeta, error) {
 	conn := db.conn
-	query := `SELECT id, image_name, image_type, description FROM image_meta WHERE id = ?`
+	query := `SELECT id, image_name, image_type, description, size, width, height, created_at, updated_at
+		FROM image_meta WHERE id = ?`
 
 	inBytes, err := inUUID.MarshalBinary()
 	if err != nil {
@@ -122,8 +129,10 @@ func (db *Database) QueryImage(inUUID uuid.UUID) (*ImageMeta, error) {
 	row := conn.QueryRow(query, inBytes)
 
 	meta := &ImageMeta{}
+	var createdAt, updatedAt int64
 	var uuidBlob []byte // this read is useless, but idk if I can just dev/null with scanner
-	err = row.Scan(&uuidBlob, &meta.ImageName, &meta.ImageType, &meta.Description)
+	err = row.Scan(&uuidBlob, &meta.ImageName, &meta.ImageType, &meta.Description,
+		&meta.Size, &meta.Width, &meta.Height, &createdAt, &updatedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -137,6 +146,9 @@ func (db *Database) QueryImage(inUUID uuid.UUID) (*ImageMeta, error) {
 		return nil, err
 	}
 
+	meta.CreatedAt = unixOrZero(createdAt)
+	meta.UpdatedAt = unixOrZero(updatedAt)
+
 	query = `SELECT tag FROM image_tags WHERE id = ?`
 	rows, err := conn.Query(query, uuidBlob)
 	if err != nil {
@@ -161,20 +173,133 @@ func (db *Database) QueryImage(inUUID uuid.UUID) (*ImageMeta, error) {
 	return meta, nil
 }
 
+// UpdateImageMeta applies patch in a single transaction and returns the updated record,
+// or nil when no image has the id
+func (db *Database) UpdateImageMeta(id uuid.UUID, patch *MetadataPatch) (*ImageMeta, error) {
+	idBytes, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	trsn, err := db.conn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer trsn.Rollback()
+
+	var exists int
+	err = trsn.QueryRow(`SELECT COUNT(*) FROM image_meta WHERE id = ?`, idBytes).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists == 0 {
+		return nil, nil
+	}
+
+	_, err = trsn.Exec(`UPDATE image_meta SET updated_at = ? WHERE id = ?`, time.Now().Unix(), idBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if patch.Title != nil {
+		if _, err = trsn.Exec(`UPDATE image_meta SET image_name = ? WHERE id = ?`, *patch.Title, idBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if patch.Description != nil {
+		if _, err = trsn.Exec(`UPDATE image_meta SET description = ? WHERE id = ?`, *patch.Description, idBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if tags := patch.Tags; tags != nil {
+		if tags.Replace != nil {
+			if _, err = trsn.Exec(`DELETE FROM image_tags WHERE id = ?`, idBytes); err != nil {
+				return nil, err
+			}
+
+			if err = insertTags(trsn, idBytes, *tags.Replace); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, tag := range tags.Remove {
+			if _, err = trsn.Exec(`DELETE FROM image_tags WHERE id = ? AND tag = ?`, idBytes, tag); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = insertTags(trsn, idBytes, tags.Add); err != nil {
+			return nil, err
+		}
+
+		if err = indexTags(trsn, idBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = trsn.Commit(); err != nil {
+		return nil, err
+	}
+
+	return db.QueryImage(id)
+}
+
+// insertTags adds tags the image doesn't already carry
+func insertTags(trsn *sql.Tx, idBytes []byte, tags []string) error {
+	query := `INSERT INTO image_tags (id, tag) SELECT ?, ? WHERE NOT EXISTS (SELECT 1 FROM image_tags WHERE id = ? AND tag = ?)`
+	for _, tag := range tags {
+		if _, err := trsn.Exec(query, idBytes, tag, idBytes, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexTags rewrites the search index tags of an image once its tag writes are done
+func indexTags(trsn *sql.Tx, idBytes []byte) error {
+	query := `UPDATE image_search SET tags = COALESCE((SELECT group_concat(tag, ' ') FROM image_tags WHERE id = ?1), '')
+		WHERE rowid = (SELECT search_id FROM image_search_ids WHERE id = ?1)`
+	_, err := trsn.Exec(query, idBytes)
+	return err
+}
+
 func (db *Database) QueryIds(limit int, offset int) ([]uuid.UUID, error) {
+	return db.QueryIdsFiltered(nil, limit, offset)
+}
+
+// QueryIdsFiltered pages through image ids matching the tag filter, a nil filter matches everything
+func (db *Database) QueryIdsFiltered(filter *TagFilter, limit int, offset int) ([]uuid.UUID, error) {
 	if limit <= 0 || offset < 0 {
 		return nil, fmt.Errorf("limit or offset out of bounds limit: %d, offset: %d", limit, offset)
 	}
 
-	conn := db.conn
-	query := `SELECT id FROM image_meta LIMIT ? OFFSET ?`
+	where, args := filter.where()
+	query := `SELECT id FROM image_meta` + where + ` ORDER BY rowid LIMIT ? OFFSET ?`
+
+	rows, err := db.conn.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanIds(rows)
+}
 
-	rows, err := conn.Query(query, limit, offset)
+func (db *Database) QueryAllIds() ([]uuid.UUID, error) {
+	rows, err := db.conn.Query(`SELECT id FROM image_meta`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanIds(rows)
+}
+
+func scanIds(rows *sql.Rows) ([]uuid.UUID, error) {
 	var uuids []uuid.UUID
 	for rows.Next() {
 		var tmp []byte
@@ -190,15 +315,149 @@ func (db *Database) QueryIds(limit int, offset int) ([]uuid.UUID, error) {
 		uuids = append(uuids, uuid)
 	}
 
-	return uuids, nil
+	return uuids, rows.Err()
+}
+
+// where renders the filter as a WHERE clause over image_meta, the tag lookups are
+// served by the image_tags_tag index
+func (filter *TagFilter) where() (string, []any) {
+	if filter == nil {
+		return "", nil
+	}
+
+	var clauses []string
+	var args []any
+	if len(filter.Tags) > 0 {
+		clause := `id IN (SELECT id FROM image_tags WHERE tag IN (` + placeholders(len(filter.Tags)) + `)`
+		for _, tag := range filter.Tags {
+			args = append(args, tag)
+		}
+
+		if filter.MatchAll {
+			clause += ` GROUP BY id HAVING COUNT(DISTINCT tag) = ?`
+			args = append(args, len(uniqueStrings(filter.Tags)))
+		}
+		clauses = append(clauses, clause+`)`)
+	}
+
+	if len(filter.Exclude) > 0 {
+		clauses = append(clauses, `id NOT IN (SELECT id FROM image_tags WHERE tag IN (`+placeholders(len(filter.Exclude))+`))`)
+		for _, tag := range filter.Exclude {
+			args = append(args, tag)
+		}
+	}
+
+	if len(clauses) == 0 {
+		return "", nil
+	}
+
+	return ` WHERE ` + strings.Join(clauses, ` AND `), args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+
+	return unique
+}
+
+func unixOrZero(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0).UTC()
 }
 
 func (db *Database) CountEntries() (int, error) {
+	return db.CountEntriesFiltered(nil)
+}
+
+func (db *Database) CountEntriesFiltered(filter *TagFilter) (int, error) {
 	var count int
-	err := db.conn.QueryRow("SELECT COUNT(*) FROM image_meta").Scan(&count)
+	where, args := filter.where()
+	err := db.conn.QueryRow("SELECT COUNT(*) FROM image_meta"+where, args...).Scan(&count)
 	if err != nil {
 		return -1, err
 	}
 
 	return count, nil
 }
+
+func (db *Database) UpsertDerivative(derivative *Derivative) error {
+	idBytes, err := derivative.Id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO image_derivatives (id, size, max_dim, format, image_type, width, height) VALUES ( ?, ?, ?, ?, ?, ?, ? )
+		ON CONFLICT (id, size) DO UPDATE SET max_dim = excluded.max_dim, format = excluded.format,
+		image_type = excluded.image_type, width = excluded.width, height = excluded.height`
+	_, err = db.conn.Exec(query, idBytes, derivative.Size, derivative.MaxDim, derivative.Format, derivative.ImageType, derivative.Width, derivative.Height)
+	return err
+}
+
+func (db *Database) QueryDerivative(id uuid.UUID, size string) (*Derivative, error) {
+	idBytes, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT size, max_dim, format, image_type, width, height FROM image_derivatives WHERE id = ? AND size = ?`
+	derivative := &Derivative{Id: id}
+	err = db.conn.QueryRow(query, idBytes, size).Scan(&derivative.Size, &derivative.MaxDim, &derivative.Format, &derivative.ImageType, &derivative.Width, &derivative.Height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return derivative, nil
+}
+
+func (db *Database) QueryDerivatives(id uuid.UUID) ([]Derivative, error) {
+	idBytes, err := id.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT size, max_dim, format, image_type, width, height FROM image_derivatives WHERE id = ?`
+	rows, err := db.conn.Query(query, idBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var derivatives []Derivative
+	for rows.Next() {
+		derivative := Derivative{Id: id}
+		if err := rows.Scan(&derivative.Size, &derivative.MaxDim, &derivative.Format, &derivative.ImageType, &derivative.Width, &derivative.Height); err != nil {
+			return nil, err
+		}
+		derivatives = append(derivatives, derivative)
+	}
+
+	return derivatives, rows.Err()
+}
+
+func (db *Database) DeleteDerivative(id uuid.UUID, size string) error {
+	idBytes, err := id.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.conn.Exec(`DELETE FROM image_derivatives WHERE id = ? AND size = ?`, idBytes, size)
+	return err
+}
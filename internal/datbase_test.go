@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func insertTestImage(t *testing.T, db *Database, tags ...string) uuid.UUID {
+	t.Helper()
+
+	id := uuid.New()
+	trsn, err := db.BeginImageUpload(id, testMetadata(tags...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := trsn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func TestQueryIdsFiltered(t *testing.T) {
+	db := NewTestStore(t).Database
+
+	grayBelly := insertTestImage(t, db, "gray", "belly")
+	gray := insertTestImage(t, db, "gray")
+	belly := insertTestImage(t, db, "belly", "sleepy")
+
+	tests := []struct {
+		name     string
+		filter   *TagFilter
+		expected []uuid.UUID
+	}{
+		{"no filter", nil, []uuid.UUID{grayBelly, gray, belly}},
+		{"all", &TagFilter{Tags: []string{"gray", "belly"}, MatchAll: true}, []uuid.UUID{grayBelly}},
+		{"all duplicate tags", &TagFilter{Tags: []string{"gray", "gray"}, MatchAll: true}, []uuid.UUID{grayBelly, gray}},
+		{"any", &TagFilter{Tags: []string{"gray", "sleepy"}}, []uuid.UUID{grayBelly, gray, belly}},
+		{"exclude", &TagFilter{Tags: []string{"belly"}, Exclude: []string{"sleepy"}}, []uuid.UUID{grayBelly}},
+		{"exclude only", &TagFilter{Exclude: []string{"gray"}}, []uuid.UUID{belly}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids, err := db.QueryIdsFiltered(test.filter, 20, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			count, err := db.CountEntriesFiltered(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ids) != len(test.expected) || count != len(test.expected) {
+				t.Fatalf("Expected %v, but got %v with count %d", test.expected, ids, count)
+			}
+
+			for i := range ids {
+				if ids[i] != test.expected[i] {
+					t.Errorf("Expected %v, but got %v", test.expected, ids)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateImageMeta(t *testing.T) {
+	db := NewTestStore(t).Database
+	id := insertTestImage(t, db, "gray", "belly")
+
+	tests := []struct {
+		name        string
+		patch       string
+		title       string
+		description string
+		tags        []string
+	}{
+		{"title", `{"title": "Grayva"}`, "Grayva", "", []string{"gray", "belly"}},
+		{"description", `{"description": "nap time"}`, "Grayva", "nap time", []string{"gray", "belly"}},
+		{"add and remove", `{"tags": {"add": ["sleepy", "gray"], "remove": ["belly"]}}`, "Grayva", "nap time", []string{"gray", "sleepy"}},
+		{"replace", `{"tags": ["loaf"]}`, "Grayva", "nap time", []string{"loaf"}},
+		{"null clears", `{"description": null, "tags": null}`, "Grayva", "", nil},
+	}
+
+	for _, test := range tests {
+		var patch MetadataPatch
+		if err := json.Unmarshal([]byte(test.patch), &patch); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		meta, err := db.UpdateImageMeta(id, &patch)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if meta.ImageName != test.title || meta.Description != test.description || !slices.Equal(meta.Tags, test.tags) {
+			t.Errorf("%s: expected %s %q %v, but got %s %q %v", test.name, test.title, test.description, test.tags, meta.ImageName, meta.Description, meta.Tags)
+		}
+	}
+
+	for _, invalid := range []string{`{"title": null}`, `{"title": " "}`, `{"size": 1}`, `{"tags": {"rename": []}}`, `{"tags": [""]}`, `[]`} {
+		var patch MetadataPatch
+		if err := json.Unmarshal([]byte(invalid), &patch); err == nil {
+			t.Errorf("Expected patch %s to be rejected", invalid)
+		}
+	}
+
+	meta, err := db.UpdateImageMeta(uuid.New(), &MetadataPatch{})
+	if err != nil || meta != nil {
+		t.Errorf("Expected nil for unknown image, but got %v %v", meta, err)
+	}
+}
+
+func TestWithPragmas(t *testing.T) {
+	tests := map[string]string{
+		"file:gdn.sqlite":                              "file:gdn.sqlite?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)",
+		"file:gdn.sqlite?_pragma=busy_timeout(50)":     "file:gdn.sqlite?_pragma=busy_timeout(50)&_pragma=journal_mode(WAL)",
+		"file:gdn.sqlite?_pragma=JOURNAL_MODE(delete)": "file:gdn.sqlite?_pragma=JOURNAL_MODE(delete)&_pragma=busy_timeout(10000)",
+	}
+
+	for dsn, expected := range tests {
+		if got := withPragmas(dsn); got != expected {
+			t.Errorf("Expected %s, but got %s", expected, got)
+		}
+	}
+}
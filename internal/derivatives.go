@@ -0,0 +1,301 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+)
+
+const DerivativeBucket = "derivatives"
+
+// originals with more pixels than this aren't decoded, 50 megapixels take 200MB as RGBA
+const maxDecodePixels = 50_000_000
+
+var DefaultDerivativeSizes = []DerivativeSize{
+	{Name: "thumb", MaxDim: 256, Format: "jpeg"},
+	{Name: "medium", MaxDim: 1024, Format: "jpeg"},
+	{Name: "thumb-webp", MaxDim: 256, Format: "webp"},
+	{Name: "medium-webp", MaxDim: 1024, Format: "webp"},
+}
+
+var derivativeTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// ParseDerivativeSizes parses a list like "thumb:256:jpeg,medium:1024:webp"
+func ParseDerivativeSizes(sizes string) ([]DerivativeSize, error) {
+	var parsed []DerivativeSize
+	seen := make(map[string]bool)
+	for entry := range strings.SplitSeq(sizes, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("derivative size %q must look like name:max_dimension:format", entry)
+		}
+
+		maxDim, err := strconv.Atoi(parts[1])
+		if err != nil || maxDim < 1 {
+			return nil, fmt.Errorf("derivative size %q has an invalid max dimension", entry)
+		}
+
+		size := DerivativeSize{Name: parts[0], MaxDim: maxDim, Format: parts[2]}
+		if err := size.validate(); err != nil {
+			return nil, err
+		}
+
+		if seen[size.Name] {
+			return nil, fmt.Errorf("derivative size %q declared twice", size.Name)
+		}
+		seen[size.Name] = true
+
+		parsed = append(parsed, size)
+	}
+
+	return parsed, nil
+}
+
+func (size DerivativeSize) validate() error {
+	if size.Name == "" || strings.ContainsAny(size.Name, `/\_`) {
+		return fmt.Errorf("derivative size name %q is invalid", size.Name)
+	}
+
+	if _, ok := derivativeTypes[size.Format]; !ok {
+		return fmt.Errorf("derivative size %q has unsupported format %q", size.Name, size.Format)
+	}
+
+	return nil
+}
+
+func DerivativeKey(id uuid.UUID, size string) string {
+	return id.String() + "_" + size
+}
+
+func NewDerivativeWorker(store *FileStore, bucket string, sizes []DerivativeSize) *DerivativeWorker {
+	return &DerivativeWorker{store: store, bucket: bucket, Sizes: sizes, queue: make(chan uuid.UUID, 64)}
+}
+
+// Start processes queued images and runs a Regenerate pass in the background until ctx
+// is done or Stop is called
+func (dw *DerivativeWorker) Start(ctx context.Context) {
+	ctx, dw.cancel = context.WithCancel(ctx)
+	dw.running.Go(func() { dw.process(ctx) })
+	dw.running.Go(func() {
+		if err := dw.Regenerate(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			log.Println("Derivative regeneration stopped", err)
+		}
+	})
+}
+
+// Stop cancels the work started by Start and waits for it to return, so the store and
+// database can be closed afterwards
+func (dw *DerivativeWorker) Stop() {
+	if dw.cancel != nil {
+		dw.cancel()
+	}
+	dw.Wait()
+}
+
+// Wait blocks until the work started by Start has returned
+func (dw *DerivativeWorker) Wait() {
+	dw.running.Wait()
+}
+
+func (dw *DerivativeWorker) process(ctx context.Context) {
+	for {
+		select {
+		case id := <-dw.queue:
+			if err := dw.Generate(ctx, id); err != nil {
+				log.Println("Unable to generate derivatives for", id, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Enqueue schedules derivative generation without blocking the uploader, if the queue is
+// full the image is skipped and picked up by the next Regenerate pass
+func (dw *DerivativeWorker) Enqueue(id uuid.UUID) {
+	select {
+	case dw.queue <- id:
+	default:
+		log.Println("Derivative queue full, skipping", id)
+	}
+}
+
+// Regenerate queues every stored image so derivatives follow the configured size list
+func (dw *DerivativeWorker) Regenerate(ctx context.Context) error {
+	const page = 100
+	for offset := 0; ; offset += page {
+		ids, err := dw.store.Database.QueryIds(page, offset)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			select {
+			case dw.queue <- id:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if len(ids) < page {
+			return nil
+		}
+	}
+}
+
+// Generate brings the derivatives of a single image in line with the configured sizes,
+// the original is only downloaded when something is missing or stale
+func (dw *DerivativeWorker) Generate(ctx context.Context, id uuid.UUID) error {
+	existing, err := dw.store.Database.QueryDerivatives(id)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]Derivative)
+	for _, derivative := range existing {
+		current[derivative.Size] = derivative
+	}
+
+	var stale []DerivativeSize
+	for _, size := range dw.Sizes {
+		derivative, ok := current[size.Name]
+		if !ok || derivative.MaxDim != size.MaxDim || derivative.Format != size.Format {
+			stale = append(stale, size)
+		}
+		delete(current, size.Name)
+	}
+
+	// anything left over is no longer configured
+	for name := range current {
+		if err := dw.store.DeleteObject(ctx, DerivativeBucket, DerivativeKey(id, name)); err != nil {
+			return err
+		}
+
+		if err := dw.store.Database.DeleteDerivative(id, name); err != nil {
+			return err
+		}
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	object, _, err := dw.store.GetObject(ctx, dw.bucket, id.String())
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil // deleted before we got to it
+	}
+
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	// the header is checked first, a small file can declare dimensions which take
+	// gigabytes to decode
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to decode original: %w", err)
+	}
+
+	if pixels := int64(config.Width) * int64(config.Height); pixels > maxDecodePixels {
+		log.Printf("Skipping derivatives of %s, %dx%d is over %d pixels\n", id, config.Width, config.Height, maxDecodePixels)
+		return nil
+	}
+
+	original, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to decode original: %w", err)
+	}
+
+	for _, size := range stale {
+		scaled := scaleToFit(original, size.MaxDim)
+
+		buf := &bytes.Buffer{}
+		if err := encodeDerivative(buf, scaled, size.Format); err != nil {
+			return err
+		}
+
+		derivative := &Derivative{
+			Id:        id,
+			Size:      size.Name,
+			MaxDim:    size.MaxDim,
+			Format:    size.Format,
+			ImageType: derivativeTypes[size.Format],
+			Width:     scaled.Bounds().Dx(),
+			Height:    scaled.Bounds().Dy(),
+		}
+
+		err = dw.store.PutObject(ctx, DerivativeBucket, DerivativeKey(id, size.Name), buf, int64(buf.Len()), derivative.ImageType)
+		if err != nil {
+			return err
+		}
+
+		if err := dw.store.Database.UpsertDerivative(derivative); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scaleToFit shrinks the image so neither side exceeds maxDim, images are never upscaled
+func scaleToFit(src image.Image, maxDim int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxDim && height <= maxDim {
+		return src
+	}
+
+	if width >= height {
+		height = max(1, height*maxDim/width)
+		width = maxDim
+	} else {
+		width = max(1, width*maxDim/height)
+		height = maxDim
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+func encodeDerivative(buf *bytes.Buffer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(buf, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(buf, img)
+	case "webp":
+		return nativewebp.Encode(buf, img, nil)
+	default:
+		return fmt.Errorf("unsupported derivative format %q", format)
+	}
+}
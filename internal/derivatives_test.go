@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestParseDerivativeSizes(t *testing.T) {
+	sizes, err := ParseDerivativeSizes("thumb:256:jpeg, medium:1024:webp")
+	if err != nil || len(sizes) != 2 || sizes[1] != (DerivativeSize{Name: "medium", MaxDim: 1024, Format: "webp"}) {
+		t.Errorf("Expected two parsed sizes, but got %v %v", sizes, err)
+	}
+
+	for _, invalid := range []string{"thumb:256", "thumb:0:jpeg", "thumb:256:bmp", "a_b:10:png", "x:1:png,x:2:png"} {
+		if _, err := ParseDerivativeSizes(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestDerivativeGeneration(t *testing.T) {
+	store := NewTestStore(t)
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.UploadFS(store.Context, "images", &Metadata{Title: "wide.png", ImageType: "image/png"}, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := store.Database.QueryIds(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := ids[0]
+
+	worker := NewDerivativeWorker(store, "images", []DerivativeSize{{Name: "thumb", MaxDim: 100, Format: "webp"}})
+	if err := worker.Generate(store.Context, id); err != nil {
+		t.Fatal(err)
+	}
+
+	derivative, err := store.Database.QueryDerivative(id, "thumb")
+	if err != nil || derivative == nil {
+		t.Fatalf("Expected a thumb derivative, but got %v %v", derivative, err)
+	}
+
+	if derivative.Width != 100 || derivative.Height != 50 || derivative.ImageType != "image/webp" {
+		t.Errorf("Expected a 100x50 image/webp thumb, but got %dx%d %s", derivative.Width, derivative.Height, derivative.ImageType)
+	}
+
+	if _, err := store.StatObject(store.Context, DerivativeBucket, DerivativeKey(id, "thumb")); err != nil {
+		t.Errorf("Expected thumb object to be stored, but got %v", err)
+	}
+
+	// changing the size list replaces stale derivatives and drops removed ones
+	worker.Sizes = []DerivativeSize{{Name: "medium", MaxDim: 1000, Format: "jpeg"}}
+	if err := worker.Generate(store.Context, id); err != nil {
+		t.Fatal(err)
+	}
+
+	derivatives, err := store.Database.QueryDerivatives(id)
+	if err != nil || len(derivatives) != 1 || derivatives[0].Size != "medium" || derivatives[0].Width != 600 {
+		t.Errorf("Expected only an unscaled medium derivative, but got %v %v", derivatives, err)
+	}
+}
+
+func TestDerivativePixelLimit(t *testing.T) {
+	store := NewTestStore(t)
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite the IHDR chunk to declare 30000x30000, the file stays tiny
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 30000)
+	binary.BigEndian.PutUint32(data[20:], 30000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	if err := store.UploadFS(store.Context, "images", &Metadata{Title: "bomb.png", ImageType: "image/png"}, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := store.Database.QueryIds(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := NewDerivativeWorker(store, "images", []DerivativeSize{{Name: "thumb", MaxDim: 100, Format: "jpeg"}})
+	if err := worker.Generate(store.Context, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if derivatives, err := store.Database.QueryDerivatives(ids[0]); err != nil || len(derivatives) != 0 {
+		t.Errorf("Expected the oversized image to be skipped, but got %v %v", derivatives, err)
+	}
+}
+
+func TestDerivativeWorkerStop(t *testing.T) {
+	store := NewTestStore(t)
+	worker := NewDerivativeWorker(store, "images", []DerivativeSize{{Name: "thumb", MaxDim: 100, Format: "webp"}})
+	worker.Start(context.Background())
+
+	stopped := make(chan struct{})
+	go func() {
+		worker.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Stop to return once the worker stopped")
+	}
+
+	// nothing is left running, so the database can be closed
+	if err := store.Database.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,46 +3,89 @@ package internal
 import (
 	"bufio"
 	"context"
+	"database/sql"
+	"image"
 	"io"
+	"log"
 	"os"
 
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/google/uuid"
 )
 
 func NewObjectStore(address string) *FileStore {
-	store := FileStore{address: address, Context: context.Background(), Connected: false, buckets: make(map[string]bool)}
+	return NewFileStore(NewMinioStore(address))
+}
+
+// ImageBucket is the default bucket for original images
+const ImageBucket = "images"
+
+var DefaultUploadLimits = UploadLimits{MaxImage: 50 << 20, MaxMetadata: 25 << 10}
+
+func NewFileStore(blobs BlobStore) *FileStore {
+	store := FileStore{
+		Context:   context.Background(),
+		Blobs:     blobs,
+		Lockouts:  NewLockouts(),
+		Bucket:    ImageBucket,
+		Uploads:   DefaultUploadLimits,
+		Connected: false,
+	}
 	return &store
 }
 
+// Connect connects the underlying BlobStore, backends which need no credentials are
+// simply marked connected
 func (store *FileStore) Connect(username string, password string) error {
-	client, err := minio.New(store.address, &minio.Options{
-		Creds:           credentials.NewStaticV4(username, password, ""),
-		TrailingHeaders: true,
-		Secure:          false,
-	})
-
-	if err != nil {
-		return err
+	if conn, ok := store.Blobs.(interface{ Connect(string, string) error }); ok {
+		if err := conn.Connect(username, password); err != nil {
+			return err
+		}
 	}
 
-	store.Client = client
 	store.Connected = true
 	return nil
 }
 
 func (store *FileStore) Close() error {
-	store.Client = nil
+	if closer, ok := store.Blobs.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+
 	store.Connected = false
 	return nil
 }
 
+func (store *FileStore) PutObject(context context.Context, bucket string, key string, reader io.Reader, size int64, contentType string) error {
+	return store.Blobs.Put(context, bucket, key, reader, size, contentType)
+}
+
+func (store *FileStore) GetObject(context context.Context, bucket string, key string) (io.ReadSeekCloser, *BlobInfo, error) {
+	return store.Blobs.Get(context, bucket, key)
+}
+
+func (store *FileStore) StatObject(context context.Context, bucket string, key string) (*BlobInfo, error) {
+	return store.Blobs.Stat(context, bucket, key)
+}
+
+func (store *FileStore) DeleteObject(context context.Context, bucket string, key string) error {
+	return store.Blobs.Delete(context, bucket, key)
+}
+
+func (store *FileStore) ListObjects(context context.Context, bucket string) ([]BlobInfo, error) {
+	return store.Blobs.List(context, bucket)
+}
+
+// swapped out by tests to simulate a failing commit
+var commitUpload = func(trsn *sql.Tx) error {
+	return trsn.Commit()
+}
+
 // Uploads to FileStore by redirecting to a temporary file before uploading
 // this increases CPU costs, but prevents large data amounts being loaded into
 // memory
-func (store *FileStore) UploadFS(context context.Context, bucket string, metadata *Metadata, reader io.Reader) error {
-	store.createBucketIfNotExists(bucket)
-
+func (store *FileStore) UploadFS(ctx context.Context, bucket string, metadata *Metadata, reader io.Reader) error {
 	file, err := os.CreateTemp("", "tmpfile-")
 	if err != nil {
 		return err
@@ -52,67 +95,78 @@ func (store *FileStore) UploadFS(context context.Context, bucket string, metadat
 	defer os.Remove(file.Name())
 
 	writer := bufio.NewWriter(file)
-	buf := make([]byte, 1024)
-	for {
-		n, err := reader.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
-		}
+	size, err := io.Copy(writer, reader)
+	if err != nil {
+		return err
+	}
 
-		if n == 0 { // very suspect
-			break
-		}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
 
-		if _, err := writer.Write(buf[:n]); err != nil {
-			return err
-		}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
 
-	if err = writer.Flush(); err != nil {
+	// dimensions are best effort, formats we can't decode are still stored
+	metadata.Size = size
+	if config, _, err := image.DecodeConfig(file); err == nil {
+		metadata.Width, metadata.Height = config.Width, config.Height
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
 
-	// Now we do read :joy:
-	uuid, err := store.Database.UploadImageMeta(metadata) // abort now if we can't post metadata
+	uuid, err := uuid.NewRandom()
 	if err != nil {
 		return err
 	}
 
 	str := uuid.String()
 
-	_, err = store.Client.FPutObject(context, bucket, str, file.Name(), minio.PutObjectOptions{})
+	// Now we do read :joy:
+	// the object goes first, metadata only becomes visible once it is durably stored
+	err = store.PutObject(ctx, bucket, str, file, size, metadata.ImageType)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (store *FileStore) createBucketIfNotExists(bucket string) error {
-	_, ok := store.buckets[bucket]
-	if ok {
-		return nil
+	trsn, err := store.Database.BeginImageUpload(uuid, metadata)
+	if err == nil {
+		err = commitUpload(trsn)
 	}
 
-	result, err := store.Client.BucketExists(context.Background(), bucket)
 	if err != nil {
+		// the request context may already be gone, compensation must still run
+		if delErr := store.DeleteObject(context.Background(), bucket, str); delErr != nil {
+			log.Println("[SEVERE] Couldn't remove object", str, "after failed metadata upload, manual removal IS REQUIRED", delErr)
+		}
 		return err
 	}
 
-	if result {
-		store.buckets[bucket] = true
-		return nil
+	if store.Derivatives != nil {
+		store.Derivatives.Enqueue(uuid)
 	}
 
-	err = store.Client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{
-		Region: "us-east-1",
-	})
+	return nil
+}
 
+// DeleteDerivatives removes every derivative object recorded for an image, the
+// database rows are left for Database.DeleteImage
+func (store *FileStore) DeleteDerivatives(context context.Context, id uuid.UUID) error {
+	derivatives, err := store.Database.QueryDerivatives(id)
 	if err != nil {
 		return err
 	}
 
-	store.buckets[bucket] = true
+	for _, derivative := range derivatives {
+		err = store.DeleteObject(context, DerivativeBucket, DerivativeKey(id, derivative.Size))
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var errInjected = errors.New("injected failure")
+
+// faultyStore wraps a real BlobStore and fails the steps it is told to
+type faultyStore struct {
+	BlobStore
+	failPut bool
+	deleted []string
+}
+
+func (fs *faultyStore) Put(ctx context.Context, bucket string, key string, reader io.Reader, size int64, contentType string) error {
+	if fs.failPut {
+		return errInjected
+	}
+
+	return fs.BlobStore.Put(ctx, bucket, key, reader, size, contentType)
+}
+
+func (fs *faultyStore) Delete(ctx context.Context, bucket string, key string) error {
+	fs.deleted = append(fs.deleted, key)
+	return fs.BlobStore.Delete(ctx, bucket, key)
+}
+
+func newFaultyStore(t *testing.T) (*FileStore, *faultyStore) {
+	store := NewTestStore(t)
+	faulty := &faultyStore{BlobStore: store.Blobs}
+	store.Blobs = faulty
+	return store, faulty
+}
+
+func assertNothingStored(t *testing.T, store *FileStore) {
+	t.Helper()
+
+	var metaRows, tagRows int
+	store.Database.conn.QueryRow(`SELECT COUNT(*) FROM image_meta`).Scan(&metaRows)
+	store.Database.conn.QueryRow(`SELECT COUNT(*) FROM image_tags`).Scan(&tagRows)
+	if metaRows != 0 || tagRows != 0 {
+		t.Errorf("Expected no metadata, but got %d meta rows and %d tag rows", metaRows, tagRows)
+	}
+
+	objects, err := store.ListObjects(store.Context, "images")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("Expected no objects, but got %v %v", objects, err)
+	}
+}
+
+func testMetadata(tags ...string) *Metadata {
+	return &Metadata{Title: "cat.png", ImageType: "image/png", Tags: tags}
+}
+
+func TestUploadSucceeds(t *testing.T) {
+	store, _ := newFaultyStore(t)
+
+	if err := store.UploadFS(store.Context, "images", testMetadata("gray", "belly"), strings.NewReader("meow")); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := store.Database.QueryIds(1, 0)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("Expected one image, but got %v %v", ids, err)
+	}
+
+	meta, err := store.Database.QueryImage(ids[0])
+	if err != nil || len(meta.Tags) != 2 {
+		t.Errorf("Expected two tags, but got %v %v", meta, err)
+	}
+
+	if _, err := store.StatObject(store.Context, "images", ids[0].String()); err != nil {
+		t.Errorf("Expected object to be stored, but got %v", err)
+	}
+}
+
+func TestUploadPutFailure(t *testing.T) {
+	store, faulty := newFaultyStore(t)
+	faulty.failPut = true
+
+	err := store.UploadFS(store.Context, "images", testMetadata("gray"), strings.NewReader("meow"))
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("Expected injected failure, but got %v", err)
+	}
+
+	assertNothingStored(t, store)
+}
+
+func TestUploadTagFailure(t *testing.T) {
+	store, faulty := newFaultyStore(t)
+
+	_, err := store.Database.conn.Exec(`CREATE TRIGGER fail_tag BEFORE INSERT ON image_tags
+		WHEN NEW.tag = 'boom' BEGIN SELECT RAISE(ABORT, 'injected failure'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.UploadFS(store.Context, "images", testMetadata("gray", "boom"), strings.NewReader("meow"))
+	if err == nil {
+		t.Fatal("Expected tag insert to fail")
+	}
+
+	if len(faulty.deleted) != 1 {
+		t.Errorf("Expected the stored object to be compensated, but got deletes %v", faulty.deleted)
+	}
+
+	assertNothingStored(t, store)
+}
+
+func TestUploadCommitFailure(t *testing.T) {
+	store, faulty := newFaultyStore(t)
+
+	commit := commitUpload
+	defer func() { commitUpload = commit }()
+	commitUpload = func(trsn *sql.Tx) error {
+		trsn.Rollback()
+		return errInjected
+	}
+
+	err := store.UploadFS(store.Context, "images", testMetadata("gray"), strings.NewReader("meow"))
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("Expected injected failure, but got %v", err)
+	}
+
+	if len(faulty.deleted) != 1 {
+		t.Errorf("Expected the stored object to be compensated, but got deletes %v", faulty.deleted)
+	}
+
+	assertNothingStored(t, store)
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps objects on the local filesystem as root/bucket/key. It has no notion
+// of content types, so BlobInfo.ContentType is always empty
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: root}
+}
+
+func (ls *LocalStore) Put(_ context.Context, bucket string, key string, reader io.Reader, _ int64, _ string) error {
+	path, err := ls.path(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	// write next to the target and rename so readers never see a partial object
+	file, err := os.CreateTemp(dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (ls *LocalStore) Get(_ context.Context, bucket string, key string) (io.ReadSeekCloser, *BlobInfo, error) {
+	path, err := ls.path(bucket, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, localErr(err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, blobInfoFromFile(key, stat), nil
+}
+
+func (ls *LocalStore) Stat(_ context.Context, bucket string, key string) (*BlobInfo, error) {
+	path, err := ls.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, localErr(err)
+	}
+
+	return blobInfoFromFile(key, stat), nil
+}
+
+func (ls *LocalStore) Delete(_ context.Context, bucket string, key string) error {
+	path, err := ls.path(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // matches S3 semantics, deleting a missing object is not an error
+	}
+
+	return err
+}
+
+func (ls *LocalStore) List(_ context.Context, bucket string) ([]BlobInfo, error) {
+	dir, err := ls.path(bucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []BlobInfo
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		stat, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *blobInfoFromFile(entry.Name(), stat))
+	}
+
+	return infos, nil
+}
+
+func (ls *LocalStore) path(bucket string, key string) (string, error) {
+	if !validLocalName(bucket) {
+		return "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+
+	if key == "" {
+		return filepath.Join(ls.root, bucket), nil
+	}
+
+	if !validLocalName(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+
+	return filepath.Join(ls.root, bucket, key), nil
+}
+
+// keys and buckets map directly to file names so they must not be able to escape the root
+func validLocalName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\`)
+}
+
+func blobInfoFromFile(key string, stat fs.FileInfo) *BlobInfo {
+	return &BlobInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+		ETag:         fmt.Sprintf("%x-%x", stat.ModTime().UnixNano(), stat.Size()),
+	}
+}
+
+func localErr(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", ErrBlobNotFound, err)
+	}
+
+	return err
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store := NewLocalStore(t.TempDir())
+	ctx := context.Background()
+
+	err := store.Put(ctx, "images", "cat", strings.NewReader("meow"), 4, "text/plain")
+	if err != nil {
+		t.Fatalf("Put failed %v", err)
+	}
+
+	object, info, err := store.Get(ctx, "images", "cat")
+	if err != nil {
+		t.Fatalf("Get failed %v", err)
+	}
+	defer object.Close()
+
+	data, _ := io.ReadAll(object)
+	if string(data) != "meow" || info.Size != 4 {
+		t.Errorf("Expected meow of size 4, but got %s of size %d", data, info.Size)
+	}
+
+	infos, err := store.List(ctx, "images")
+	if err != nil || len(infos) != 1 || infos[0].Key != "cat" {
+		t.Errorf("Expected a single listed key cat, but got %v %v", infos, err)
+	}
+
+	if err := store.Delete(ctx, "images", "cat"); err != nil {
+		t.Fatalf("Delete failed %v", err)
+	}
+
+	if _, err := store.Stat(ctx, "images", "cat"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound, but got %v", err)
+	}
+
+	if err := store.Put(ctx, "images", "../escape", strings.NewReader(""), 0, ""); err == nil {
+		t.Errorf("Expected key ../escape to be rejected")
+	}
+}
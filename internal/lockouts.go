@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// failures below this are free, typos happen
+	freeFailures = 3
+	lockoutBase  = time.Second
+	lockoutMax   = time.Hour
+	// clients are forgotten once they haven't failed for this long
+	lockoutForget = 24 * time.Hour
+)
+
+func NewLockouts() *Lockouts {
+	return &Lockouts{clients: make(map[string]*Lockout), now: time.Now}
+}
+
+// Locked reports until when client may not attempt authentication
+func (lockouts *Lockouts) Locked(client string) (time.Time, bool) {
+	lockouts.lock.Lock()
+	defer lockouts.lock.Unlock()
+
+	entry, ok := lockouts.clients[client]
+	if !ok || !lockouts.now().Before(entry.LockedUntil) {
+		return time.Time{}, false
+	}
+
+	return entry.LockedUntil, true
+}
+
+// Fail records a failed attempt, every failure past freeFailures doubles the lockout
+// up to lockoutMax. The updated record is returned
+func (lockouts *Lockouts) Fail(client string) Lockout {
+	lockouts.lock.Lock()
+	defer lockouts.lock.Unlock()
+
+	entry, ok := lockouts.clients[client]
+	if !ok {
+		entry = &Lockout{Client: client}
+		lockouts.clients[client] = entry
+	}
+
+	now := lockouts.now()
+	entry.Failures++
+	entry.LastFailure = now.UTC()
+	if entry.Failures > freeFailures {
+		lockout := lockoutMax
+		if shift := entry.Failures - freeFailures; shift < 32 {
+			lockout = min(lockoutBase<<shift, lockoutMax)
+		}
+		entry.LockedUntil = now.Add(lockout).UTC()
+	}
+
+	return *entry
+}
+
+// Clear lifts any lockout on client, returning whether there was one
+func (lockouts *Lockouts) Clear(client string) bool {
+	lockouts.lock.Lock()
+	defer lockouts.lock.Unlock()
+
+	_, ok := lockouts.clients[client]
+	delete(lockouts.clients, client)
+	return ok
+}
+
+// List returns every client with recorded failures, most recent first
+func (lockouts *Lockouts) List() []Lockout {
+	lockouts.lock.Lock()
+	defer lockouts.lock.Unlock()
+
+	list := make([]Lockout, 0, len(lockouts.clients))
+	for _, entry := range lockouts.clients {
+		list = append(list, *entry)
+	}
+
+	slices.SortFunc(list, func(a Lockout, b Lockout) int {
+		if cmp := b.LastFailure.Compare(a.LastFailure); cmp != 0 {
+			return cmp
+		}
+		return strings.Compare(a.Client, b.Client)
+	})
+	return list
+}
+
+// Prune forgets clients which are no longer locked and haven't failed recently
+func (lockouts *Lockouts) Prune() {
+	lockouts.lock.Lock()
+	defer lockouts.lock.Unlock()
+
+	now := lockouts.now()
+	for client, entry := range lockouts.clients {
+		if now.After(entry.LockedUntil) && now.Sub(entry.LastFailure) >= lockoutForget {
+			delete(lockouts.clients, client)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockouts(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 0, 0, 0, time.UTC)
+	lockouts := NewLockouts()
+	lockouts.now = func() time.Time { return now }
+
+	for i := 1; i <= freeFailures; i++ {
+		lockouts.Fail("10.0.0.1")
+		if _, locked := lockouts.Locked("10.0.0.1"); locked {
+			t.Fatalf("Expected no lockout after %d failures", i)
+		}
+	}
+
+	// each failure past the free ones doubles the lockout
+	for i, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		entry := lockouts.Fail("10.0.0.1")
+		if lockout := entry.LockedUntil.Sub(now); lockout != expected {
+			t.Errorf("Expected failure %d to lock for %s, but got %s", freeFailures+i+1, expected, lockout)
+		}
+	}
+
+	if _, locked := lockouts.Locked("10.0.0.2"); locked {
+		t.Errorf("Expected other clients to be unaffected")
+	}
+
+	for range 64 {
+		lockouts.Fail("10.0.0.1")
+	}
+
+	until, locked := lockouts.Locked("10.0.0.1")
+	if !locked || until.Sub(now) != lockoutMax {
+		t.Errorf("Expected the lockout to be capped at %s, but got %s", lockoutMax, until.Sub(now))
+	}
+
+	now = now.Add(lockoutMax)
+	if _, locked := lockouts.Locked("10.0.0.1"); locked {
+		t.Errorf("Expected the lockout to expire")
+	}
+
+	lockouts.Fail("10.0.0.3")
+	if list := lockouts.List(); len(list) != 2 || list[0].Client != "10.0.0.3" {
+		t.Errorf("Expected the most recent failure first, but got %v", list)
+	}
+
+	if !lockouts.Clear("10.0.0.3") {
+		t.Errorf("Expected clearing a failed client to report it")
+	}
+
+	if lockouts.Clear("10.0.0.3") {
+		t.Errorf("Expected a cleared client to be forgotten")
+	}
+
+	if list := lockouts.List(); len(list) != 1 || list[0].Client != "10.0.0.1" {
+		t.Errorf("Expected only 10.0.0.1 left, but got %v", list)
+	}
+
+	now = now.Add(lockoutForget)
+	lockouts.Prune()
+	if list := lockouts.List(); len(list) != 0 {
+		t.Errorf("Expected stale clients to be pruned, but got %v", list)
+	}
+}
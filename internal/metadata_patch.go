@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// UnmarshalJSON follows RFC 7396, a null description clears it while tags accept
+// either a replacement array, null to clear them, or an add/remove/replace object
+func (patch *MetadataPatch) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	if fields == nil {
+		return errors.New("patch must be a JSON object")
+	}
+
+	for name, raw := range fields {
+		null := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+		switch name {
+		case "title":
+			if null {
+				return errors.New("title can not be removed")
+			}
+
+			var title string
+			if err := json.Unmarshal(raw, &title); err != nil {
+				return fmt.Errorf("title: %w", err)
+			}
+
+			if strings.TrimSpace(title) == "" {
+				return errors.New("title can not be empty")
+			}
+			patch.Title = &title
+		case "description":
+			description := ""
+			if !null {
+				if err := json.Unmarshal(raw, &description); err != nil {
+					return fmt.Errorf("description: %w", err)
+				}
+			}
+			patch.Description = &description
+		case "tags":
+			tags, err := parseTagPatch(raw, null)
+			if err != nil {
+				return fmt.Errorf("tags: %w", err)
+			}
+			patch.Tags = tags
+		default:
+			return fmt.Errorf("unknown field %q", name)
+		}
+	}
+
+	return nil
+}
+
+func parseTagPatch(raw json.RawMessage, null bool) (*TagPatch, error) {
+	if null {
+		return &TagPatch{Replace: &[]string{}}, nil
+	}
+
+	var replace []string
+	if err := json.Unmarshal(raw, &replace); err == nil {
+		return &TagPatch{Replace: &replace}, validTags(replace)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	tags := &TagPatch{}
+	if err := decoder.Decode(tags); err != nil {
+		return nil, errors.New("must be an array or an object of add, remove and replace")
+	}
+
+	if tags.Replace != nil {
+		if err := validTags(*tags.Replace); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validTags(tags.Add); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func validTags(tags []string) error {
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("tags can not be empty")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrations are read once from files named <version>_<name>.<up|down>.sql
+var migrations = mustLoadMigrations(migrationFiles)
+
+func mustLoadMigrations(files fs.FS) []Migration {
+	loaded, err := loadMigrations(files)
+	if err != nil {
+		panic(err)
+	}
+
+	return loaded
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	entries, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		base := path.Base(entry)
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s must be named <version>_<name>.<up|down>.sql", base)
+		}
+
+		rawVersion, name, _ := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s has an invalid version", base)
+		}
+
+		data, err := fs.ReadFile(files, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d used by both %s and %s", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	var loaded []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		loaded = append(loaded, *migration)
+	}
+
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Version < loaded[j].Version })
+	for i, migration := range loaded {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous, missing version %d", i+1)
+		}
+	}
+
+	return loaded, nil
+}
+
+// LatestSchemaVersion is the newest schema this binary knows how to use
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+func (db *Database) SchemaVersion() (int, error) {
+	_, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return -1, err
+	}
+
+	var version int
+	err = db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return -1, err
+	}
+
+	return version, nil
+}
+
+// CheckSchema refuses databases written by a newer binary
+func (db *Database) CheckSchema() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("%w: database is at version %d, binary knows up to %d", ErrSchemaTooNew, version, LatestSchemaVersion())
+	}
+
+	return nil
+}
+
+// Migrate brings the schema up to LatestSchemaVersion
+func (db *Database) Migrate() error {
+	return db.MigrateTo(LatestSchemaVersion())
+}
+
+// MigrateTo applies up or down migrations until the schema is at target, each
+// migration runs in its own transaction
+func (db *Database) MigrateTo(target int) error {
+	if target < 0 || target > LatestSchemaVersion() {
+		return fmt.Errorf("schema version %d out of range 0-%d", target, LatestSchemaVersion())
+	}
+
+	if err := db.CheckSchema(); err != nil {
+		return err
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for version < target {
+		migration := migrations[version]
+		err = db.applyMigration(migration.Up, func(trsn *sql.Tx) error {
+			_, err := trsn.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES ( ?, ?, ? )`,
+				migration.Version, migration.Name, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+		}
+		version++
+	}
+
+	for version > target {
+		migration := migrations[version-1]
+		if migration.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+
+		err = db.applyMigration(migration.Down, func(trsn *sql.Tx) error {
+			_, err := trsn.Exec(`DELETE FROM schema_version WHERE version = ?`, migration.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+		}
+		version--
+	}
+
+	return nil
+}
+
+func (db *Database) applyMigration(script string, record func(*sql.Tx) error) error {
+	trsn, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer trsn.Rollback()
+
+	if _, err := trsn.Exec(script); err != nil {
+		return err
+	}
+
+	if err := record(trsn); err != nil {
+		return err
+	}
+
+	return trsn.Commit()
+}
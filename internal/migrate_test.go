@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateUpDown(t *testing.T) {
+	db, err := NewDBConnection("file:" + filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil || version != LatestSchemaVersion() {
+		t.Fatalf("Expected version %d, but got %d %v", LatestSchemaVersion(), version, err)
+	}
+
+	if err := db.MigrateTo(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CountEntries(); err == nil {
+		t.Errorf("Expected image_meta to be dropped after migrating down to 0")
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.CountEntries(); err != nil {
+		t.Errorf("Expected image_meta after migrating back up, but got %v", err)
+	}
+
+	_, err = db.conn.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES ( ?, 'future', 0 )`, LatestSchemaVersion()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Migrate(); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, but got %v", err)
+	}
+}
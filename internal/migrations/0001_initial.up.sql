@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS image_meta (
+	id BLOB PRIMARY KEY,
+	image_name TEXT NOT NULL,
+	image_type TEXT NOT NULL,
+	description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS image_tags (
+	id BLOB,
+	tag TEXT
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS image_derivatives (
+	id BLOB NOT NULL,
+	size TEXT NOT NULL,
+	max_dim INTEGER NOT NULL,
+	format TEXT NOT NULL,
+	image_type TEXT NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	PRIMARY KEY (id, size)
+);
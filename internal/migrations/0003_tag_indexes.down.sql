@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS image_tags_id;
+DROP INDEX IF EXISTS image_tags_tag;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS image_tags_tag ON image_tags (tag, id);
+CREATE INDEX IF NOT EXISTS image_tags_id ON image_tags (id);
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS image_search_tags_delete;
+DROP TRIGGER IF EXISTS image_search_tags_insert;
+DROP TRIGGER IF EXISTS image_search_meta_delete;
+DROP TRIGGER IF EXISTS image_search_meta_update;
+DROP TRIGGER IF EXISTS image_search_meta_insert;
+DROP TABLE IF EXISTS image_search;
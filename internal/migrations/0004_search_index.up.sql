@@ -0,0 +1,38 @@
+CREATE VIRTUAL TABLE IF NOT EXISTS image_search USING fts5 (
+	id UNINDEXED,
+	title,
+	description,
+	tags,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+-- index everything uploaded before search existed
+DELETE FROM image_search;
+INSERT INTO image_search (id, title, description, tags)
+	SELECT m.id, m.image_name, COALESCE(m.description, ''),
+		COALESCE((SELECT group_concat(t.tag, ' ') FROM image_tags t WHERE t.id = m.id), '')
+	FROM image_meta m;
+
+CREATE TRIGGER IF NOT EXISTS image_search_meta_insert AFTER INSERT ON image_meta BEGIN
+	INSERT INTO image_search (id, title, description, tags)
+		VALUES (NEW.id, NEW.image_name, COALESCE(NEW.description, ''), '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS image_search_meta_update AFTER UPDATE ON image_meta BEGIN
+	UPDATE image_search SET id = NEW.id, title = NEW.image_name, description = COALESCE(NEW.description, '')
+		WHERE id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS image_search_meta_delete AFTER DELETE ON image_meta BEGIN
+	DELETE FROM image_search WHERE id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS image_search_tags_insert AFTER INSERT ON image_tags BEGIN
+	UPDATE image_search SET tags = (SELECT group_concat(tag, ' ') FROM image_tags WHERE id = NEW.id)
+		WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS image_search_tags_delete AFTER DELETE ON image_tags BEGIN
+	UPDATE image_search SET tags = COALESCE((SELECT group_concat(tag, ' ') FROM image_tags WHERE id = OLD.id), '')
+		WHERE id = OLD.id;
+END;
@@ -0,0 +1,5 @@
+ALTER TABLE image_meta DROP COLUMN updated_at;
+ALTER TABLE image_meta DROP COLUMN created_at;
+ALTER TABLE image_meta DROP COLUMN height;
+ALTER TABLE image_meta DROP COLUMN width;
+ALTER TABLE image_meta DROP COLUMN size;
@@ -0,0 +1,6 @@
+-- photos uploaded before this migration keep zeroes, their size and dimensions are unknown
+ALTER TABLE image_meta ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE image_meta ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE image_meta ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE image_meta ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE image_meta ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS api_keys_active_name;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS sessions_expires_at;
+DROP INDEX IF EXISTS sessions_key_name;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,12 @@
+-- id is the sha256 of the cookie value so a leaked database can't be replayed as cookies
+CREATE TABLE IF NOT EXISTS sessions (
+	id BLOB PRIMARY KEY,
+	key_name TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	csrf TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_key_name ON sessions (key_name);
+CREATE INDEX IF NOT EXISTS sessions_expires_at ON sessions (expires_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS bans_expires_at;
+DROP TABLE IF EXISTS bans;
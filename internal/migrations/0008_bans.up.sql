@@ -0,0 +1,12 @@
+-- expires_at is 0 for bans which never expire
+CREATE TABLE IF NOT EXISTS bans (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	cidr TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	origin TEXT NOT NULL,
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS bans_expires_at ON bans (expires_at);
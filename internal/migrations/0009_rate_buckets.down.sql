@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS rate_buckets_updated_at;
+DROP TABLE IF EXISTS rate_buckets;
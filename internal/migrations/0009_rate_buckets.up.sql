@@ -0,0 +1,9 @@
+-- token buckets shared by every instance using this database, updated_at is in
+-- fractional unix seconds
+CREATE TABLE IF NOT EXISTS rate_buckets (
+	bucket TEXT PRIMARY KEY,
+	tokens REAL NOT NULL,
+	updated_at REAL NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_buckets_updated_at ON rate_buckets (updated_at);
@@ -0,0 +1,38 @@
+-- key the search index by image_meta's rowid so the triggers update a single row
+-- instead of scanning for the UNINDEXED id. That rowid isn't stable, 0012 keys the
+-- index by image_search_ids instead
+DROP TRIGGER IF EXISTS image_search_tags_delete;
+DROP TRIGGER IF EXISTS image_search_tags_insert;
+DROP TRIGGER IF EXISTS image_search_meta_delete;
+DROP TRIGGER IF EXISTS image_search_meta_update;
+DROP TRIGGER IF EXISTS image_search_meta_insert;
+DROP TABLE IF EXISTS image_search;
+
+CREATE VIRTUAL TABLE image_search USING fts5 (
+	title,
+	description,
+	tags,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO image_search (rowid, title, description, tags)
+	SELECT m.rowid, m.image_name, COALESCE(m.description, ''),
+		COALESCE((SELECT group_concat(t.tag, ' ') FROM image_tags t WHERE t.id = m.id), '')
+	FROM image_meta m;
+
+CREATE TRIGGER image_search_meta_insert AFTER INSERT ON image_meta BEGIN
+	INSERT INTO image_search (rowid, title, description, tags)
+		VALUES (NEW.rowid, NEW.image_name, COALESCE(NEW.description, ''), '');
+END;
+
+CREATE TRIGGER image_search_meta_update AFTER UPDATE OF image_name, description ON image_meta BEGIN
+	UPDATE image_search SET title = NEW.image_name, description = COALESCE(NEW.description, '')
+		WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER image_search_meta_delete AFTER DELETE ON image_meta BEGIN
+	DELETE FROM image_search WHERE rowid = OLD.rowid;
+END;
+
+-- tags are indexed by Database.indexTags once their writes are done, a trigger would
+-- rebuild them once per tag row
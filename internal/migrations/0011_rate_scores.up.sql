@@ -0,0 +1,7 @@
+-- behavior scores shared by every instance using this database, a score decays from
+-- its value at updated_at which is in fractional unix seconds
+CREATE TABLE IF NOT EXISTS rate_scores (
+	client TEXT PRIMARY KEY,
+	score REAL NOT NULL,
+	updated_at REAL NOT NULL
+);
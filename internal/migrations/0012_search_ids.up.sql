@@ -0,0 +1,42 @@
+-- image_meta has no INTEGER PRIMARY KEY, so a VACUUM or a dump and restore may renumber
+-- its rowid and point search results at the wrong images. The index is keyed by
+-- image_search_ids instead, whose INTEGER PRIMARY KEY never changes
+DROP TRIGGER IF EXISTS image_search_meta_delete;
+DROP TRIGGER IF EXISTS image_search_meta_update;
+DROP TRIGGER IF EXISTS image_search_meta_insert;
+DROP TABLE IF EXISTS image_search;
+
+CREATE TABLE IF NOT EXISTS image_search_ids (
+	search_id INTEGER PRIMARY KEY,
+	id BLOB NOT NULL UNIQUE
+);
+
+INSERT INTO image_search_ids (id) SELECT id FROM image_meta;
+
+CREATE VIRTUAL TABLE image_search USING fts5 (
+	title,
+	description,
+	tags,
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO image_search (rowid, title, description, tags)
+	SELECT s.search_id, m.image_name, COALESCE(m.description, ''),
+		COALESCE((SELECT group_concat(t.tag, ' ') FROM image_tags t WHERE t.id = m.id), '')
+	FROM image_search_ids s JOIN image_meta m ON m.id = s.id;
+
+CREATE TRIGGER image_search_meta_insert AFTER INSERT ON image_meta BEGIN
+	INSERT INTO image_search_ids (id) VALUES (NEW.id);
+	INSERT INTO image_search (rowid, title, description, tags)
+		VALUES ((SELECT search_id FROM image_search_ids WHERE id = NEW.id), NEW.image_name, COALESCE(NEW.description, ''), '');
+END;
+
+CREATE TRIGGER image_search_meta_update AFTER UPDATE OF image_name, description ON image_meta BEGIN
+	UPDATE image_search SET title = NEW.image_name, description = COALESCE(NEW.description, '')
+		WHERE rowid = (SELECT search_id FROM image_search_ids WHERE id = NEW.id);
+END;
+
+CREATE TRIGGER image_search_meta_delete AFTER DELETE ON image_meta BEGIN
+	DELETE FROM image_search WHERE rowid = (SELECT search_id FROM image_search_ids WHERE id = OLD.id);
+	DELETE FROM image_search_ids WHERE id = OLD.id;
+END;
@@ -0,0 +1,3 @@
+-- sha256 of the ADMIN_SECRET a root session logged in with, sessions from before this
+-- column have none and are logged out
+ALTER TABLE sessions ADD COLUMN root_secret BLOB;
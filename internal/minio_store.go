@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type MinioStore struct {
+	address string
+	// Secure dials MinIO over TLS, CABundle is a PEM file of extra trusted CAs for
+	// servers with private certificates
+	Secure   bool
+	CABundle string
+	client   *minio.Client
+	buckets  map[string]bool
+	block    sync.Mutex
+}
+
+func NewMinioStore(address string) *MinioStore {
+	return &MinioStore{address: address, buckets: make(map[string]bool)}
+}
+
+func (ms *MinioStore) Connect(username string, password string) error {
+	options := &minio.Options{
+		Creds:           credentials.NewStaticV4(username, password, ""),
+		TrailingHeaders: true,
+		Secure:          ms.Secure,
+	}
+
+	if ms.Secure && ms.CABundle != "" {
+		transport, err := caTransport(ms.CABundle)
+		if err != nil {
+			return err
+		}
+		options.Transport = transport
+	}
+
+	client, err := minio.New(ms.address, options)
+
+	if err != nil {
+		return err
+	}
+
+	ms.client = client
+	return nil
+}
+
+// caTransport trusts the CAs in bundle on top of the system ones
+func caTransport(bundle string) (*http.Transport, error) {
+	pem, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", bundle)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return transport, nil
+}
+
+func (ms *MinioStore) Close() error {
+	ms.client = nil
+	return nil
+}
+
+func (ms *MinioStore) Put(ctx context.Context, bucket string, key string, reader io.Reader, size int64, contentType string) error {
+	if err := ms.createBucketIfNotExists(ctx, bucket); err != nil {
+		return err
+	}
+
+	_, err := ms.client.PutObject(ctx, bucket, key, reader, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (ms *MinioStore) Get(ctx context.Context, bucket string, key string) (io.ReadSeekCloser, *BlobInfo, error) {
+	obj, err := ms.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, minioErr(err)
+	}
+
+	// GetObject is lazy, the object doesn't hit the network until it is read or stat'd
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, minioErr(err)
+	}
+
+	return obj, blobInfoFromMinio(info), nil
+}
+
+func (ms *MinioStore) Stat(ctx context.Context, bucket string, key string) (*BlobInfo, error) {
+	info, err := ms.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, minioErr(err)
+	}
+
+	return blobInfoFromMinio(info), nil
+}
+
+func (ms *MinioStore) Delete(ctx context.Context, bucket string, key string) error {
+	return minioErr(ms.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{}))
+}
+
+func (ms *MinioStore) List(ctx context.Context, bucket string) ([]BlobInfo, error) {
+	var infos []BlobInfo
+	for info := range ms.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if info.Err != nil {
+			if minio.ToErrorResponse(info.Err).Code == minio.NoSuchBucket {
+				return nil, nil
+			}
+			return nil, info.Err
+		}
+		infos = append(infos, *blobInfoFromMinio(info))
+	}
+
+	return infos, nil
+}
+
+func (ms *MinioStore) createBucketIfNotExists(ctx context.Context, bucket string) error {
+	ms.block.Lock()
+	defer ms.block.Unlock()
+
+	if ms.buckets[bucket] {
+		return nil
+	}
+
+	result, err := ms.client.BucketExists(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	if !result {
+		err = ms.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{
+			Region: "us-east-1",
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	ms.buckets[bucket] = true
+	return nil
+}
+
+func blobInfoFromMinio(info minio.ObjectInfo) *BlobInfo {
+	return &BlobInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+	}
+}
+
+func minioErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	code := minio.ToErrorResponse(err).Code
+	if code == minio.NoSuchKey || code == minio.NoSuchBucket {
+		return fmt.Errorf("%w: %v", ErrBlobNotFound, err)
+	}
+
+	return err
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TakeToken refills bucket at limit tokens per second up to burst and takes one token
+// if there is one, all in a single statement so concurrent instances can't both take
+// the last token. The tokens left afterwards are returned
+func (db *Database) TakeToken(bucket string, limit float64, burst int, now time.Time) (bool, float64, error) {
+	seconds := unixSeconds(now)
+
+	// a clock behind the stored update never refills negatively
+	query := `INSERT INTO rate_buckets (bucket, tokens, updated_at) VALUES ( ?1, ?3 - 1, ?4 )
+		ON CONFLICT (bucket) DO UPDATE SET
+			tokens = MIN(?3, tokens + MAX(0, ?4 - updated_at) * ?2) - 1,
+			updated_at = MAX(updated_at, ?4)
+		WHERE MIN(?3, tokens + MAX(0, ?4 - updated_at) * ?2) >= 1
+		RETURNING tokens`
+
+	var tokens float64
+	err := db.conn.QueryRow(query, bucket, limit, burst, seconds).Scan(&tokens)
+	if err == nil {
+		return true, tokens, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+
+	query = `SELECT MIN(?3, tokens + MAX(0, ?4 - updated_at) * ?2) FROM rate_buckets WHERE bucket = ?1`
+	if err := db.conn.QueryRow(query, bucket, limit, burst, seconds).Scan(&tokens); err != nil {
+		return false, 0, err
+	}
+
+	return false, tokens, nil
+}
+
+// PruneRateBuckets removes buckets untouched since cutoff
+func (db *Database) PruneRateBuckets(cutoff time.Time) (int64, error) {
+	rslt, err := db.conn.Exec(`DELETE FROM rate_buckets WHERE updated_at < ?`, unixSeconds(cutoff))
+	if err != nil {
+		return 0, err
+	}
+
+	return rslt.RowsAffected()
+}
+
+func unixSeconds(date time.Time) float64 {
+	return float64(date.UnixMicro()) / 1e6
+}
+
+// AddScore adds points to the behavior score of client after decaying it by decay points
+// per second since its last update, a client without a score starts at first instead.
+// The new score is returned
+func (db *Database) AddScore(client string, points float64, first float64, decay float64, now time.Time) (float64, error) {
+	query := `INSERT INTO rate_scores (client, score, updated_at) VALUES ( ?1, ?3, ?5 )
+		ON CONFLICT (client) DO UPDATE SET
+			score = MAX(0, score - MAX(0, ?5 - updated_at) * ?4) + ?2,
+			updated_at = MAX(updated_at, ?5)
+		RETURNING score`
+
+	var score float64
+	err := db.conn.QueryRow(query, client, points, first, decay, unixSeconds(now)).Scan(&score)
+	return score, err
+}
+
+// ResetScore removes the score of client if its decayed score still reaches threshold,
+// of concurrent calls only one resets it. The score it had is returned
+func (db *Database) ResetScore(client string, threshold float64, decay float64, now time.Time) (float64, bool, error) {
+	query := `DELETE FROM rate_scores WHERE client = ?1 AND score - MAX(0, ?4 - updated_at) * ?3 >= ?2
+		RETURNING score - MAX(0, ?4 - updated_at) * ?3`
+
+	var score float64
+	err := db.conn.QueryRow(query, client, threshold, decay, unixSeconds(now)).Scan(&score)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return score, true, nil
+}
+
+// PruneRateScores removes scores which had decayed to nothing by cutoff
+func (db *Database) PruneRateScores(cutoff time.Time, decay float64) (int64, error) {
+	rslt, err := db.conn.Exec(`DELETE FROM rate_scores WHERE score - MAX(0, ?2 - updated_at) * ?1 <= 0`, decay, unixSeconds(cutoff))
+	if err != nil {
+		return 0, err
+	}
+
+	return rslt.RowsAffected()
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// objects younger than this may belong to an upload whose metadata is not committed yet
+var reconcileGrace = 10 * time.Minute
+
+// Reconcile compares the objects in bucket with image_meta and reports both sides of any
+// mismatch, when repair is set orphaned objects and metadata are removed
+func (store *FileStore) Reconcile(ctx context.Context, bucket string, repair bool) (*ReconcileReport, error) {
+	ids, err := store.Database.QueryAllIds()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+
+	report := &ReconcileReport{OrphanObjects: []string{}, OrphanMeta: []uuid.UUID{}, OrphanDerivatives: []string{}, Repaired: repair}
+
+	objects, err := store.ListObjects(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[uuid.UUID]bool, len(objects))
+	for _, object := range objects {
+		id, err := uuid.Parse(object.Key)
+		if err == nil {
+			stored[id] = true
+			if known[id] {
+				continue
+			}
+		}
+
+		if time.Since(object.LastModified) < reconcileGrace {
+			continue
+		}
+		report.OrphanObjects = append(report.OrphanObjects, object.Key)
+	}
+
+	for _, id := range ids {
+		if !stored[id] {
+			report.OrphanMeta = append(report.OrphanMeta, id)
+		}
+	}
+
+	derivatives, err := store.ListObjects(ctx, DerivativeBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range derivatives {
+		rawId, _, _ := strings.Cut(object.Key, "_")
+		id, err := uuid.Parse(rawId)
+		if err == nil && known[id] && stored[id] {
+			continue
+		}
+
+		if time.Since(object.LastModified) < reconcileGrace {
+			continue
+		}
+		report.OrphanDerivatives = append(report.OrphanDerivatives, object.Key)
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, key := range report.OrphanObjects {
+		if err := store.DeleteObject(ctx, bucket, key); err != nil {
+			return report, err
+		}
+		log.Println("Reconcile removed object without metadata", key)
+	}
+
+	for _, id := range report.OrphanMeta {
+		if err := store.DeleteDerivatives(ctx, id); err != nil {
+			return report, err
+		}
+
+		if err := store.Database.DeleteImage(id); err != nil {
+			return report, err
+		}
+		log.Println("Reconcile removed metadata without object", id)
+	}
+
+	for _, key := range report.OrphanDerivatives {
+		if err := store.DeleteObject(ctx, DerivativeBucket, key); err != nil {
+			return report, err
+		}
+		log.Println("Reconcile removed orphaned derivative", key)
+	}
+
+	return report, nil
+}
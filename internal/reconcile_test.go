@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReconcile(t *testing.T) {
+	store := NewTestStore(t)
+
+	grace := reconcileGrace
+	defer func() { reconcileGrace = grace }()
+	reconcileGrace = 0
+
+	if err := store.UploadFS(store.Context, "images", testMetadata("gray"), strings.NewReader("meow")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.PutObject(store.Context, "images", "stray", strings.NewReader("hiss"), 4, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := uuid.New()
+	trsn, err := store.Database.BeginImageUpload(missing, testMetadata("lost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trsn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.Reconcile(store.Context, "images", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.OrphanObjects) != 1 || report.OrphanObjects[0] != "stray" {
+		t.Errorf("Expected orphan object stray, but got %v", report.OrphanObjects)
+	}
+
+	if len(report.OrphanMeta) != 1 || report.OrphanMeta[0] != missing {
+		t.Errorf("Expected orphan metadata %s, but got %v", missing, report.OrphanMeta)
+	}
+
+	// dry run must not have touched anything
+	if _, err := store.StatObject(store.Context, "images", "stray"); err != nil {
+		t.Errorf("Expected stray to survive a dry run, but got %v", err)
+	}
+
+	if _, err := store.Reconcile(store.Context, "images", true); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = store.Reconcile(store.Context, "images", false)
+	if err != nil || len(report.OrphanObjects) != 0 || len(report.OrphanMeta) != 0 {
+		t.Errorf("Expected a clean report after repair, but got %v %v", report, err)
+	}
+
+	count, err := store.Database.CountEntries()
+	if err != nil || count != 1 {
+		t.Errorf("Expected the healthy image to remain, but got %d %v", count, err)
+	}
+}
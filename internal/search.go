@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrEmptySearch = errors.New("search query has no terms")
+
+// snippet markers are control characters so user text can be escaped before
+// they are swapped for <mark> tags
+const (
+	markOpen  = "\x02"
+	markClose = "\x03"
+)
+
+// BuildSearchQuery turns free text into an FTS5 query where every word is a
+// quoted prefix match, so user input can never use FTS5 syntax
+func BuildSearchQuery(text string) (string, error) {
+	var terms []string
+	for word := range strings.FieldsSeq(text) {
+		word = strings.Map(func(r rune) rune {
+			if r == '"' || r == '*' || r < ' ' {
+				return -1
+			}
+			return r
+		}, word)
+
+		if word != "" {
+			terms = append(terms, `"`+word+`"*`)
+		}
+	}
+
+	if len(terms) == 0 {
+		return "", ErrEmptySearch
+	}
+
+	return strings.Join(terms, " "), nil
+}
+
+// SearchImages ranks images by how well their title, description and tags match text,
+// titles weigh the most followed by tags
+func (db *Database) SearchImages(text string, limit int, offset int) ([]SearchResult, error) {
+	if limit <= 0 || offset < 0 {
+		return nil, fmt.Errorf("limit or offset out of bounds limit: %d, offset: %d", limit, offset)
+	}
+
+	match, err := BuildSearchQuery(text)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT s.id,
+		highlight(image_search, 0, ?, ?),
+		snippet(image_search, -1, ?, ?, '…', 12),
+		bm25(image_search, 10.0, 1.0, 5.0) AS score
+		FROM image_search JOIN image_search_ids s ON s.search_id = image_search.rowid
+		WHERE image_search MATCH ?
+		ORDER BY score LIMIT ? OFFSET ?`
+
+	rows, err := db.conn.Query(query, markOpen, markClose, markOpen, markClose, match, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var idBytes []byte
+		var result SearchResult
+		if err := rows.Scan(&idBytes, &result.Title, &result.Snippet, &result.Score); err != nil {
+			return nil, err
+		}
+
+		result.Id, err = uuid.FromBytes(idBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		// bm25 is negative with the best match lowest, flip it for readers
+		result.Score = -result.Score
+		result.Title = highlightHTML(result.Title)
+		result.Snippet = highlightHTML(result.Snippet)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, markOpen, "<mark>")
+	return strings.ReplaceAll(text, markClose, "</mark>")
+}
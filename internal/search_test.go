@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBuildSearchQuery(t *testing.T) {
+	query, err := BuildSearchQuery(`gray "be*lly OR`)
+	if err != nil || query != `"gray"* "belly"* "OR"*` {
+		t.Errorf("Expected quoted prefix terms, but got %s %v", query, err)
+	}
+
+	if _, err := BuildSearchQuery(` "" * `); err != ErrEmptySearch {
+		t.Errorf("Expected ErrEmptySearch, but got %v", err)
+	}
+}
+
+func TestSearchImages(t *testing.T) {
+	db, err := NewDBConnection("file:" + filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// rows written before the search migration must be indexed by it
+	if err := db.MigrateTo(3); err != nil {
+		t.Fatal(err)
+	}
+	old := uuid.New()
+	oldBytes, _ := old.MarshalBinary()
+	_, err = db.conn.Exec(`INSERT INTO image_meta (id, image_name, image_type, description) VALUES ( ?, 'cat.png', 'image/png', '' )`, oldBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.conn.Exec(`INSERT INTO image_tags (id, tag) VALUES ( ?, 'gray' )`, oldBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	titled := uuid.New()
+	trsn, err := db.BeginImageUpload(titled, &Metadata{Title: "Belly <up>", ImageType: "image/png", Description: "a nap"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trsn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tagged := insertTestImage(t, db, "belly")
+
+	results, err := db.SearchImages("bel", 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0].Id != titled || results[1].Id != tagged {
+		t.Fatalf("Expected title match ranked before tag match, but got %v", results)
+	}
+
+	if results[0].Title != "<mark>Belly</mark> &lt;up&gt;" {
+		t.Errorf("Expected escaped highlighted title, but got %s", results[0].Title)
+	}
+
+	results, err = db.SearchImages("gray", 20, 0)
+	if err != nil || len(results) != 1 || results[0].Id != old {
+		t.Errorf("Expected pre-migration image to be found, but got %v %v", results, err)
+	}
+
+	// tag edits are reindexed once per patch
+	_, err = db.UpdateImageMeta(tagged, &MetadataPatch{Tags: &TagPatch{Add: []string{"tabby"}, Remove: []string{"belly"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = db.SearchImages("tabby", 20, 0)
+	if err != nil || len(results) != 1 || results[0].Id != tagged {
+		t.Errorf("Expected the added tag to be indexed, but got %v %v", results, err)
+	}
+
+	results, err = db.SearchImages("bel", 20, 0)
+	if err != nil || len(results) != 1 || results[0].Id != titled {
+		t.Errorf("Expected the removed tag to leave the index, but got %v %v", results, err)
+	}
+
+	if err := db.DeleteImage(old); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = db.SearchImages("gray", 20, 0)
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected deleted image to leave the index, but got %v %v", results, err)
+	}
+
+	// a VACUUM or a dump and restore may renumber image_meta, which has no INTEGER PRIMARY KEY
+	if _, err := db.conn.Exec(`UPDATE image_meta SET rowid = rowid + 100`); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = db.SearchImages("tabby", 20, 0)
+	if err != nil || len(results) != 1 || results[0].Id != tagged {
+		t.Errorf("Expected results to keep their ids once image_meta is renumbered, but got %v %v", results, err)
+	}
+
+	results, err = db.SearchImages("nap", 20, 0)
+	if err != nil || len(results) != 1 || results[0].Id != titled {
+		t.Errorf("Expected results to keep their ids once image_meta is renumbered, but got %v %v", results, err)
+	}
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// SessionIdle is how long a session survives without being used
+	SessionIdle = 2 * time.Hour
+	// SessionLifetime caps a session no matter how often it is refreshed
+	SessionLifetime = 7 * 24 * time.Hour
+)
+
+// CreateSession starts a session for key, returning it along with the token for the
+// cookie. Only the hash of the token is stored, root sessions also keep a hash of the
+// ADMIN_SECRET so they end once it changes
+func (db *Database) CreateSession(key *APIKey) (*Session, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	csrf, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &Session{
+		KeyName:   key.Name,
+		Scopes:    key.Scopes,
+		CSRFToken: csrf,
+		CreatedAt: now.UTC().Truncate(time.Second),
+		ExpiresAt: now.Add(SessionIdle).UTC().Truncate(time.Second),
+	}
+
+	var root []byte
+	if key.Name == RootKeyName {
+		root = rootSecretHash()
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	query := `INSERT INTO sessions (id, key_name, scopes, csrf, created_at, expires_at, root_secret) VALUES ( ?, ?, ?, ?, ?, ?, ? )`
+	_, err = db.conn.Exec(query, hash[:], session.KeyName, strings.Join(session.Scopes, " "), csrf, session.CreatedAt.Unix(), session.ExpiresAt.Unix(), root)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+// LookupSession returns the live session for token or nil. Sessions past half their
+// idle time are pushed back out to SessionIdle, up to SessionLifetime. Root sessions
+// created with another ADMIN_SECRET are removed
+func (db *Database) LookupSession(token string) (*Session, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	row := db.conn.QueryRow(`SELECT key_name, scopes, csrf, created_at, expires_at, root_secret FROM sessions WHERE id = ?`, hash[:])
+
+	session := &Session{}
+	var scopes string
+	var createdAt, expiresAt int64
+	var root []byte
+	err := row.Scan(&session.KeyName, &scopes, &session.CSRFToken, &createdAt, &expiresAt, &root)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	session.Scopes = strings.Fields(scopes)
+	session.CreatedAt = time.Unix(createdAt, 0).UTC()
+	session.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+
+	rotated := session.KeyName == RootKeyName && subtle.ConstantTimeCompare(root, rootSecretHash()) != 1
+
+	now := time.Now()
+	if rotated || !now.Before(session.ExpiresAt) {
+		_, err := db.conn.Exec(`DELETE FROM sessions WHERE id = ?`, hash[:])
+		return nil, err
+	}
+
+	if session.ExpiresAt.Sub(now) < SessionIdle/2 {
+		expires := now.Add(SessionIdle).UTC().Truncate(time.Second)
+		if limit := session.CreatedAt.Add(SessionLifetime); expires.After(limit) {
+			expires = limit
+		}
+
+		if expires.After(session.ExpiresAt) {
+			if _, err := db.conn.Exec(`UPDATE sessions SET expires_at = ? WHERE id = ?`, expires.Unix(), hash[:]); err != nil {
+				return nil, err
+			}
+			session.ExpiresAt = expires
+		}
+	}
+
+	return session, nil
+}
+
+func (db *Database) DeleteSession(token string) error {
+	hash := sha256.Sum256([]byte(token))
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE id = ?`, hash[:])
+	return err
+}
+
+// DeleteSessionsFor logs out every session of the named key
+func (db *Database) DeleteSessionsFor(name string) error {
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE key_name = ?`, name)
+	return err
+}
+
+// PruneSessions removes expired sessions, returning how many were removed
+func (db *Database) PruneSessions() (int64, error) {
+	rslt, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return rslt.RowsAffected()
+}
+
+// APIKey is the key the session acts as
+func (session *Session) APIKey() *APIKey {
+	return &APIKey{Id: "session", Name: session.KeyName, Scopes: session.Scopes}
+}
+
+// rootSecretHash identifies the current ADMIN_SECRET, nil when it isn't set
+func rootSecretHash() []byte {
+	root := os.Getenv(RootKeyName)
+	if root == "" {
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(root))
+	return hash[:]
+}
+
+func randomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionExpiry(t *testing.T) {
+	db := NewTestStore(t).Database
+
+	key := &APIKey{Name: "uploader", Scopes: []string{ScopePhotosWrite}}
+	session, token, err := db.CreateSession(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nearly idle sessions get pushed back out on use
+	soon := time.Now().Add(time.Minute).Unix()
+	if _, err := db.conn.Exec(`UPDATE sessions SET expires_at = ?`, soon); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := db.LookupSession(token)
+	if err != nil || found == nil || found.CSRFToken != session.CSRFToken {
+		t.Fatalf("Expected the session to be found, but got %v %v", found, err)
+	}
+
+	if found.ExpiresAt.Unix() <= soon {
+		t.Errorf("Expected the session to be refreshed past %d, but got %d", soon, found.ExpiresAt.Unix())
+	}
+
+	// refreshing never goes past the absolute lifetime
+	created := time.Now().Add(-SessionLifetime + time.Minute).Unix()
+	if _, err := db.conn.Exec(`UPDATE sessions SET created_at = ?, expires_at = ?`, created, soon); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = db.LookupSession(token)
+	if err != nil || found == nil || found.ExpiresAt.Unix() != created+int64(SessionLifetime/time.Second) {
+		t.Errorf("Expected the refresh to stop at the session lifetime, but got %v %v", found, err)
+	}
+
+	if _, err := db.conn.Exec(`UPDATE sessions SET expires_at = ?`, time.Now().Add(-time.Second).Unix()); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := db.LookupSession(token); err != nil || found != nil {
+		t.Errorf("Expected an expired session to be rejected, but got %v %v", found, err)
+	}
+
+	if _, token, err = db.CreateSession(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteSessionsFor("uploader"); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := db.LookupSession(token); found != nil {
+		t.Errorf("Expected sessions of the key to be removed")
+	}
+}
+
+func TestRootSessionRotation(t *testing.T) {
+	db := NewTestStore(t).Database
+	t.Setenv("ADMIN_SECRET", "first_secret_value")
+
+	root, err := db.Authenticate("first_secret_value")
+	if err != nil || root == nil {
+		t.Fatalf("Expected the root key, but got %v %v", root, err)
+	}
+
+	_, token, err := db.CreateSession(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := db.LookupSession(token); err != nil || found == nil {
+		t.Fatalf("Expected the root session to be found, but got %v %v", found, err)
+	}
+
+	// rotating the secret ends sessions logged in with the old one
+	t.Setenv("ADMIN_SECRET", "second_secret_value")
+	if found, err := db.LookupSession(token); err != nil || found != nil {
+		t.Errorf("Expected the root session to end with the old secret, but got %v %v", found, err)
+	}
+
+	t.Setenv("ADMIN_SECRET", "first_secret_value")
+	if found, _ := db.LookupSession(token); found != nil {
+		t.Errorf("Expected the ended session to stay removed")
+	}
+}
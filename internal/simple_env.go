@@ -1,28 +1,445 @@
 package internal
 
 import (
-	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 )
 
+// EnvName is the environment variable naming the environment, e.g. production, whose
+// overlay .env.<name> is loaded by LoadEnv
+const EnvName = "GDN_ENV"
+
+// SecretVariables may also be given as <name>_FILE, naming a file holding the value, so
+// secrets mounted by container orchestrators never have to be in the environment
+var SecretVariables = []string{"ADMIN_SECRET", "MINIO_ACCESS_KEY", "MINIO_SECRET_KEY"}
+
+// LoadEnv loads the env files which exist, the real environment overriding .env.local,
+// which overrides .env.<GDN_ENV>, which overrides .env. GDN_ENV itself may come from any
+// of them but the overlay. The SecretVariables are then read from their files
 func LoadEnv() error {
-	return LoadEnvFrom(".env")
+	// variables which are set are kept, so the most specific file goes first
+	if err := LoadEnvFrom(".env.local"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	name, err := envName(".env")
+	if err != nil {
+		return err
+	}
+
+	files := []string{".env"}
+	if name != "" {
+		files = []string{".env." + name, ".env"}
+	}
+
+	for _, file := range files {
+		if err := LoadEnvFrom(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return LoadSecretFiles(SecretVariables...)
 }
 
+// envName is EnvName from the environment, or else from base without loading it yet,
+// since the overlay it names has to be loaded before base to override it
+func envName(base string) (string, error) {
+	if name, set := os.LookupEnv(EnvName); set {
+		return name, nil
+	}
+
+	vars, err := readEnv(base, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// the first assignment is the one LoadEnvFrom keeps
+	for _, entry := range vars {
+		if entry.key == EnvName {
+			return entry.value, nil
+		}
+	}
+	return "", nil
+}
+
+// LoadSecretFiles sets each name from the file named by <name>_FILE, if any. A single
+// trailing newline is dropped since most tools write one
+func LoadSecretFiles(names ...string) error {
+	for _, name := range names {
+		path := os.Getenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+
+		if os.Getenv(name) != "" {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %w", name, err)
+		}
+
+		value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadEnvFrom sets the variables of a dotenv file, variables which are already set
+// keep their value so the real environment always wins
 func LoadEnvFrom(environment string) error {
-	file, err := os.Open(environment)
+	return loadEnv(environment, false)
+}
+
+// OverloadEnvFrom is LoadEnvFrom but the file overrides variables which are already set
+func OverloadEnvFrom(environment string) error {
+	return loadEnv(environment, true)
+}
+
+func loadEnv(environment string, override bool) error {
+	vars, err := readEnv(environment, override)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	// nothing is set unless the whole file parsed
+	for _, entry := range vars {
+		if _, set := os.LookupEnv(entry.key); set && !override {
+			continue
+		}
 
-	for scanner.Scan() {
-		text := strings.Split(scanner.Text(), "=")
-		os.Setenv(text[0], text[1])
+		if err := os.Setenv(entry.key, entry.value); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+func readEnv(environment string, override bool) ([]envEntry, error) {
+	file, err := os.Open(environment)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseEnv(environment, file, os.LookupEnv, override)
+}
+
+type envEntry struct {
+	key   string
+	value string
+}
+
+// envParser reads the dotenv format:
+//
+//	# comments on their own line or after a value
+//	export KEY=value
+//	KEY='literal, no escapes or ${EXPANSION}'
+//	KEY="escapes \n \t \" \\ \$ and ${EXPANSION},
+//	may span lines"
+//
+// ${VAR} and $VAR are expanded in unquoted and double quoted values from the entries
+// above and the environment, unset variables expand to nothing
+type envParser struct {
+	name   string
+	src    string
+	pos    int
+	line   int
+	lookup func(string) (string, bool)
+}
+
+func parseEnv(name string, reader io.Reader, lookup func(string) (string, bool), override bool) ([]envEntry, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var vars []envEntry
+	parsed := map[string]string{}
+
+	parser := &envParser{name: name, src: strings.ReplaceAll(string(data), "\r\n", "\n"), line: 1}
+	parser.lookup = func(key string) (string, bool) {
+		value, inFile := parsed[key]
+		if inFile && override {
+			return value, true
+		}
+
+		if value, set := lookup(key); set {
+			return value, true
+		}
+		return value, inFile
+	}
+
+	for {
+		parser.skipSpace()
+		if parser.pos >= len(parser.src) {
+			return vars, nil
+		}
+
+		switch parser.src[parser.pos] {
+		case '\n':
+			parser.pos++
+			parser.line++
+		case '#':
+			parser.skipLine()
+		default:
+			key, value, err := parser.entry()
+			if err != nil {
+				return nil, err
+			}
+
+			parsed[key] = value
+			vars = append(vars, envEntry{key, value})
+		}
+	}
+}
+
+func (parser *envParser) errorf(line int, format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", parser.name, line, fmt.Sprintf(format, args...))
+}
+
+func (parser *envParser) entry() (string, string, error) {
+	line := parser.line
+	key := parser.word()
+	if key == "export" && parser.pos < len(parser.src) && isSpace(parser.src[parser.pos]) {
+		parser.skipSpace()
+		key = parser.word()
+	}
+
+	if !validEnvName(key) {
+		return "", "", parser.errorf(line, "invalid variable name %q", key)
+	}
+
+	parser.skipSpace()
+	if parser.pos >= len(parser.src) || parser.src[parser.pos] != '=' {
+		return "", "", parser.errorf(line, "expected = after %s", key)
+	}
+	parser.pos++
+	parser.skipSpace()
+
+	if parser.pos >= len(parser.src) || parser.src[parser.pos] == '\n' {
+		return key, "", nil
+	}
+
+	var value string
+	var err error
+	switch parser.src[parser.pos] {
+	case '\'':
+		value, err = parser.singleQuoted()
+	case '"':
+		value, err = parser.doubleQuoted()
+	default:
+		value, err = parser.unquoted()
+		return key, value, err
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	// only a comment may follow the closing quote
+	parser.skipSpace()
+	if parser.pos < len(parser.src) && parser.src[parser.pos] != '\n' && parser.src[parser.pos] != '#' {
+		return "", "", parser.errorf(parser.line, "unexpected %q after quoted value of %s", parser.src[parser.pos], key)
+	}
+	parser.skipLine()
+
+	return key, value, nil
+}
+
+func (parser *envParser) singleQuoted() (string, error) {
+	line := parser.line
+	parser.pos++
+	end := strings.IndexByte(parser.src[parser.pos:], '\'')
+	if end < 0 {
+		return "", parser.errorf(line, "unterminated single quoted value")
+	}
+
+	value := parser.src[parser.pos : parser.pos+end]
+	parser.line += strings.Count(value, "\n")
+	parser.pos += end + 1
+	return value, nil
+}
+
+func (parser *envParser) doubleQuoted() (string, error) {
+	line := parser.line
+	parser.pos++
+
+	var value strings.Builder
+	for parser.pos < len(parser.src) {
+		char := parser.src[parser.pos]
+		switch char {
+		case '"':
+			parser.pos++
+			return value.String(), nil
+		case '\\':
+			if parser.pos+1 >= len(parser.src) {
+				return "", parser.errorf(line, "unterminated double quoted value")
+			}
+
+			escaped := parser.src[parser.pos+1]
+			switch escaped {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '"', '\\', '$':
+				value.WriteByte(escaped)
+			case '\n':
+				// a backslash at the end of the line continues the value without a newline
+				parser.line++
+			default:
+				value.WriteByte('\\')
+				value.WriteByte(escaped)
+			}
+			parser.pos += 2
+		case '$':
+			expanded, next, err := parser.expand(parser.src, parser.pos)
+			if err != nil {
+				return "", err
+			}
+
+			value.WriteString(expanded)
+			parser.pos = next
+		default:
+			if char == '\n' {
+				parser.line++
+			}
+			value.WriteByte(char)
+			parser.pos++
+		}
+	}
+
+	return "", parser.errorf(line, "unterminated double quoted value")
+}
+
+func (parser *envParser) unquoted() (string, error) {
+	end := strings.IndexByte(parser.src[parser.pos:], '\n')
+	if end < 0 {
+		end = len(parser.src) - parser.pos
+	}
+
+	// keep the byte before the value, whitespace skipped after the = still starts a comment
+	raw := parser.src[parser.pos-1 : parser.pos+end]
+	parser.pos += end
+
+	// a # only starts a comment after whitespace, so values like a#b survive
+	for i := 1; i < len(raw); i++ {
+		if raw[i] == '#' && isSpace(raw[i-1]) {
+			raw = raw[:i]
+			break
+		}
+	}
+	raw = strings.TrimSpace(raw[1:])
+
+	var value strings.Builder
+	for i := 0; i < len(raw); {
+		if raw[i] != '$' {
+			value.WriteByte(raw[i])
+			i++
+			continue
+		}
+
+		expanded, next, err := parser.expand(raw, i)
+		if err != nil {
+			return "", err
+		}
+
+		value.WriteString(expanded)
+		i = next
+	}
+
+	return value.String(), nil
+}
+
+// expand resolves the reference starting at the $ at src[at], returning the value
+// and the index after the reference. A $ not followed by a name is kept as is
+func (parser *envParser) expand(src string, at int) (string, int, error) {
+	braced := at+1 < len(src) && src[at+1] == '{'
+	start := at + 1
+	if braced {
+		start++
+	}
+
+	end := start
+	for end < len(src) && isNameChar(src[end], end == start) {
+		end++
+	}
+
+	name := src[start:end]
+	if !braced {
+		if name == "" {
+			return "$", at + 1, nil
+		}
+
+		value, _ := parser.lookup(name)
+		return value, end, nil
+	}
+
+	if end >= len(src) || src[end] != '}' || name == "" {
+		return "", 0, parser.errorf(parser.line, "unterminated or invalid ${...} reference")
+	}
+
+	value, _ := parser.lookup(name)
+	return value, end + 1, nil
+}
+
+func (parser *envParser) word() string {
+	start := parser.pos
+	for parser.pos < len(parser.src) && !isSpace(parser.src[parser.pos]) && parser.src[parser.pos] != '=' && parser.src[parser.pos] != '\n' {
+		parser.pos++
+	}
+
+	return parser.src[start:parser.pos]
+}
+
+func (parser *envParser) skipSpace() {
+	for parser.pos < len(parser.src) && isSpace(parser.src[parser.pos]) {
+		parser.pos++
+	}
+}
+
+// skipLine moves to the newline ending the current line
+func (parser *envParser) skipLine() {
+	if end := strings.IndexByte(parser.src[parser.pos:], '\n'); end >= 0 {
+		parser.pos += end
+	} else {
+		parser.pos = len(parser.src)
+	}
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNameChar(char byte, first bool) bool {
+	return char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (!first && char >= '0' && char <= '9')
+}
+
+func isSpace(char byte) bool {
+	return char == ' ' || char == '\t'
+}
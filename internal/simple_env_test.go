@@ -2,6 +2,8 @@ package internal
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -18,3 +20,193 @@ func TestLoadEnvFrom(t *testing.T) {
 		t.Errorf("Expected %s, but got %s", expected, got)
 	}
 }
+
+func TestParseEnv(t *testing.T) {
+	environment := map[string]string{"HOME": "/home/gdn"}
+	lookup := func(key string) (string, bool) {
+		value, ok := environment[key]
+		return value, ok
+	}
+
+	tests := []struct {
+		name     string
+		src      string
+		expected map[string]string
+		err      string
+	}{
+		{"plain", "A=1\nB=two", map[string]string{"A": "1", "B": "two"}, ""},
+		{"blank lines and comments", "\n# comment\n\n  # indented\nA=1\n\n", map[string]string{"A": "1"}, ""},
+		{"equals in value", "DSN=file:gdn.sqlite?_pragma=foreign_keys(1)", map[string]string{"DSN": "file:gdn.sqlite?_pragma=foreign_keys(1)"}, ""},
+		{"spaces around", "  A =  spaced value  ", map[string]string{"A": "spaced value"}, ""},
+		{"empty", "A=\nB=", map[string]string{"A": "", "B": ""}, ""},
+		{"export", "export A=1\nexport\tB=2", map[string]string{"A": "1", "B": "2"}, ""},
+		{"inline comment", "A=1 # one\nB=a#b", map[string]string{"A": "1", "B": "a#b"}, ""},
+		{"empty with comment", "A= # note\nB=#c\nC=\t# tab", map[string]string{"A": "", "B": "#c", "C": ""}, ""},
+		{"single quoted", `A='${HOME} \n # kept'`, map[string]string{"A": `${HOME} \n # kept`}, ""},
+		{"double quoted", `A="tab\there \"quoted\" \\ \$HOME" # comment`, map[string]string{"A": "tab\there \"quoted\" \\ $HOME"}, ""},
+		{"multi-line", "KEY=\"-----BEGIN-----\nabc\n-----END-----\"\nNEXT=1", map[string]string{"KEY": "-----BEGIN-----\nabc\n-----END-----", "NEXT": "1"}, ""},
+		{"multi-line single", "A='one\ntwo'", map[string]string{"A": "one\ntwo"}, ""},
+		{"expansion", "DIR=${HOME}/gdn\nDB=$DIR/main.sqlite\nQ=\"${DIR}\"", map[string]string{"DIR": "/home/gdn/gdn", "DB": "/home/gdn/gdn/main.sqlite", "Q": "/home/gdn/gdn"}, ""},
+		{"unset expands empty", "A=x${MISSING}y", map[string]string{"A": "xy"}, ""},
+		{"lone dollar", "A=costs $5 or $", map[string]string{"A": "costs $5 or $"}, ""},
+		{"crlf", "A=1\r\nB=\"2\"\r\n", map[string]string{"A": "1", "B": "2"}, ""},
+		{"missing equals", "A=1\nJUST_A_NAME\n", nil, "test.env:2: expected = after JUST_A_NAME"},
+		{"invalid name", "\n\n1A=1", nil, "test.env:3: invalid variable name"},
+		{"unterminated double", "A=1\nB=\"open\n\n", nil, "test.env:2: unterminated double quoted value"},
+		{"unterminated single", "A='open", nil, "test.env:1: unterminated single quoted value"},
+		{"trailing garbage", "A=\"1\"2", nil, "test.env:1: unexpected"},
+		{"bad reference", "A=\nB=${A", nil, "test.env:2: unterminated or invalid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vars, err := parseEnv("test.env", strings.NewReader(test.src), lookup, false)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("Expected error %q, but got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+			for _, entry := range vars {
+				got[entry.key] = entry.value
+			}
+
+			if len(got) != len(test.expected) {
+				t.Errorf("Expected %v, but got %v", test.expected, got)
+			}
+
+			for key, value := range test.expected {
+				if got[key] != value {
+					t.Errorf("%s: expected %q, but got %q", key, value, got[key])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadEnvOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(path, []byte("GDN_TEST_SET=file\nGDN_TEST_FRESH=file\nGDN_TEST_COPY=$GDN_TEST_SET\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GDN_TEST_SET", "env")
+	t.Setenv("GDN_TEST_FRESH", "")
+	os.Unsetenv("GDN_TEST_FRESH")
+	t.Setenv("GDN_TEST_COPY", "")
+	os.Unsetenv("GDN_TEST_COPY")
+
+	if err := LoadEnvFrom(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]string{"GDN_TEST_SET": "env", "GDN_TEST_FRESH": "file", "GDN_TEST_COPY": "env"} {
+		if got := os.Getenv(key); got != expected {
+			t.Errorf("%s: expected %q, but got %q", key, expected, got)
+		}
+	}
+
+	if err := OverloadEnvFrom(path); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]string{"GDN_TEST_SET": "file", "GDN_TEST_COPY": "file"} {
+		if got := os.Getenv(key); got != expected {
+			t.Errorf("overloaded %s: expected %q, but got %q", key, expected, got)
+		}
+	}
+}
+
+func TestLoadEnvLayers(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := map[string]string{
+		".env":            "GDN_TEST_A=base\nGDN_TEST_B=base\nGDN_TEST_C=base\nGDN_TEST_D=base\n",
+		".env.production": "GDN_TEST_B=production\nGDN_TEST_C=production\n",
+		".env.staging":    "GDN_TEST_B=staging\n",
+		".env.local":      "GDN_TEST_C=local\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{"GDN_TEST_A", "GDN_TEST_B", "GDN_TEST_C"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	t.Setenv("GDN_TEST_D", "env")
+	t.Setenv(EnvName, "production")
+
+	if err := LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]string{"GDN_TEST_A": "base", "GDN_TEST_B": "production", "GDN_TEST_C": "local", "GDN_TEST_D": "env"} {
+		if got := os.Getenv(key); got != expected {
+			t.Errorf("%s: expected %q, but got %q", key, expected, got)
+		}
+	}
+}
+
+func TestLoadEnvNameFromFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := map[string]string{
+		".env":         "GDN_ENV=staging\nGDN_TEST_B=base\n",
+		".env.staging": "GDN_TEST_B=staging\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{EnvName, "GDN_TEST_B"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+
+	if err := LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the overlay named in .env still overrides it
+	if got := os.Getenv("GDN_TEST_B"); got != "staging" {
+		t.Errorf("Expected %q, but got %q", "staging", got)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("mounted_secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GDN_TEST_SECRET", "")
+	t.Setenv("GDN_TEST_SECRET_FILE", path)
+	if err := LoadSecretFiles("GDN_TEST_SECRET"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := os.Getenv("GDN_TEST_SECRET"); got != "mounted_secret" {
+		t.Errorf("Expected mounted_secret, but got %q", got)
+	}
+
+	// set twice is ambiguous
+	if err := LoadSecretFiles("GDN_TEST_SECRET"); err == nil {
+		t.Error("Expected an error with both GDN_TEST_SECRET and GDN_TEST_SECRET_FILE set")
+	}
+
+	t.Setenv("GDN_TEST_SECRET", "")
+	t.Setenv("GDN_TEST_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+	if err := LoadSecretFiles("GDN_TEST_SECRET"); err == nil {
+		t.Error("Expected an error for a missing secret file")
+	}
+}
@@ -3,28 +3,72 @@ package internal
 import (
 	"context"
 	"database/sql"
+	"net/netip"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/minio/minio-go/v7"
 )
 
+// ImageMeta is the stored record of an image, the details are zero for images
+// uploaded before they were recorded
 type ImageMeta struct {
-	Id          uuid.UUID
-	ImageName   string
-	ImageType   string
-	Description string
-	Tags        []string
+	Id          uuid.UUID `json:"id"`
+	ImageName   string    `json:"title"`
+	ImageType   string    `json:"content_type"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	Size        int64     `json:"size,omitzero"`
+	Width       int       `json:"width,omitzero"`
+	Height      int       `json:"height,omitzero"`
+	CreatedAt   time.Time `json:"created_at,omitzero"`
+	UpdatedAt   time.Time `json:"updated_at,omitzero"`
 }
 
 type Database struct {
 	conn *sql.DB
+	bans *banCache
 }
 
 type Metadata struct {
 	Title       string   `json:"title"`
 	Description string   `json:"description"`
 	Tags        []string `json:"tags"`
-	ImageType   string
+	ImageType   string   `json:"-"`
+	Size        int64    `json:"-"`
+	Width       int      `json:"-"`
+	Height      int      `json:"-"`
+}
+
+// MetadataPatch is a JSON merge patch of Metadata, nil fields are left untouched
+type MetadataPatch struct {
+	Title       *string
+	Description *string
+	Tags        *TagPatch
+}
+
+// TagPatch replaces the tag set when Replace is set, then removes and adds tags
+type TagPatch struct {
+	Replace *[]string `json:"replace"`
+	Add     []string  `json:"add"`
+	Remove  []string  `json:"remove"`
+}
+
+type TagFilter struct {
+	Tags     []string
+	MatchAll bool
+	Exclude  []string
+}
+
+type SearchResult struct {
+	Id      uuid.UUID `json:"id"`
+	Title   string    `json:"title"`
+	Snippet string    `json:"snippet"`
+	Score   float64   `json:"score"`
+}
+
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
 }
 
 type IdResponse struct {
@@ -33,10 +77,109 @@ type IdResponse struct {
 }
 
 type FileStore struct {
-	address   string
-	Context   context.Context
-	Client    *minio.Client
-	Database  *Database
+	Context     context.Context
+	Blobs       BlobStore
+	Database    *Database
+	Derivatives *DerivativeWorker
+	Lockouts    *Lockouts
+	// bucket holding the original images
+	Bucket    string
+	Uploads   UploadLimits
 	Connected bool
-	buckets   map[string]bool
+}
+
+// UploadLimits caps the parts of an upload in bytes
+type UploadLimits struct {
+	MaxImage    int64
+	MaxMetadata int64
+}
+
+type DerivativeSize struct {
+	Name   string
+	MaxDim int
+	Format string
+}
+
+type Derivative struct {
+	Id        uuid.UUID
+	Size      string
+	MaxDim    int
+	Format    string
+	ImageType string
+	Width     int
+	Height    int
+}
+
+type DerivativeWorker struct {
+	store   *FileStore
+	bucket  string
+	Sizes   []DerivativeSize
+	queue   chan uuid.UUID
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+type ReconcileReport struct {
+	OrphanObjects     []string    `json:"orphan_objects"`
+	OrphanMeta        []uuid.UUID `json:"orphan_metadata"`
+	OrphanDerivatives []string    `json:"orphan_derivatives"`
+	Repaired          bool        `json:"repaired"`
+}
+
+type APIKey struct {
+	Id         string    `json:"id"`
+	Name       string    `json:"name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at,omitzero"`
+	LastUsedAt time.Time `json:"last_used_at,omitzero"`
+	RevokedAt  time.Time `json:"revoked_at,omitzero"`
+}
+
+// Session is a browser login created from an APIKey, it carries the key's name and
+// scopes at login time
+type Session struct {
+	KeyName   string
+	Scopes    []string
+	CSRFToken string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Lockout is the failed authentication record of a single client
+type Lockout struct {
+	Client      string    `json:"client"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitzero"`
+}
+
+type Lockouts struct {
+	clients map[string]*Lockout
+	lock    sync.Mutex
+	now     func() time.Time
+}
+
+const (
+	BanAutomatic = "automatic"
+	BanManual    = "manual"
+)
+
+// Ban blocks every client inside CIDR, single addresses are stored as /32 or /128
+type Ban struct {
+	Id        int64        `json:"id"`
+	CIDR      netip.Prefix `json:"cidr"`
+	Reason    string       `json:"reason"`
+	Origin    string       `json:"origin"`
+	CreatedBy string       `json:"created_by,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at,omitzero"`
+}
+
+// banCache keeps the live bans in memory since they are checked on every request, it
+// is reloaded after changes and every banCacheTTL to pick up other processes' changes
+type banCache struct {
+	bans     []Ban
+	loadedAt time.Time
+	version  int
+	lock     sync.RWMutex
 }
@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// NewTestStore opens a migrated database and a local store in a temporary directory for
+// tests of any package, both are closed when the test ends
+func NewTestStore(t testing.TB) *FileStore {
+	dir := t.TempDir()
+	db, err := NewDBConnection("file:" + filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileStore(NewLocalStore(filepath.Join(dir, "objects")))
+	store.Database = db
+	t.Cleanup(func() { store.Close() })
+	return store
+}
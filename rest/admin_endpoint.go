@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// reconcile reports orphaned objects and metadata, repairs only happen on
+// POST with ?repair=true so GET and plain POST are always dry runs
+func reconcile(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	repair := false
+	if rqst.Method == http.MethodPost && rqst.URL.Query().Has("repair") {
+		rslt, err := strconv.ParseBool(rqst.URL.Query().Get("repair"))
+		if err != nil {
+			werr(rspn, http.StatusBadRequest)
+			return
+		}
+		repair = rslt
+	}
+
+	report, err := store.Reconcile(rqst.Context(), store.Bucket, repair)
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if repair {
+		log.Printf("Reconcile repair requested by %s removed %d objects, %d metadata rows and %d derivatives\n",
+			rqst.RemoteAddr, len(report.OrphanObjects), len(report.OrphanMeta), len(report.OrphanDerivatives))
+	}
+
+	rspn.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rspn).Encode(report); err != nil {
+		log.Println(err)
+	}
+}
@@ -1,41 +1,235 @@
 package rest
 
 import (
-	"fmt"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"log"
 	"net"
 	"net/http"
-	"os"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+const (
+	SessionCookie = "gdn_session"
+	CSRFHeader    = "X-CSRF-Token"
 )
 
-func AuthEndpoints(store *FileStore, urlPart string, rspn http.ResponseWriter, rqst *http.Request) {
-	switch rqst.Method {
-	case http.MethodGet:
-		verifyAuth(store, urlPart, rspn, rqst)
-	case http.MethodDelete:
+var ErrCSRF = errors.New("missing or invalid " + CSRFHeader)
+
+type authResponse struct {
+	Valid     bool      `json:"valid"`
+	Name      string    `json:"name,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	CSRFToken string    `json:"csrf_token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// AuthAttempt is the outcome of an X-API-Key presented with a request, so middleware
+// can track failures once the handler ran
+type AuthAttempt struct {
+	Presented bool
+	Key       *internal.APIKey
+}
+
+type authAttemptContext struct{}
+
+// TrackAuth returns a request whose authentication attempts are recorded in the
+// returned AuthAttempt
+func TrackAuth(rqst *http.Request) (*http.Request, *AuthAttempt) {
+	attempt := &AuthAttempt{}
+	return rqst.WithContext(context.WithValue(rqst.Context(), authAttemptContext{}, attempt)), attempt
+}
+
+func recordAuth(rqst *http.Request, key *internal.APIKey) {
+	if attempt, ok := rqst.Context().Value(authAttemptContext{}).(*AuthAttempt); ok {
+		attempt.Presented = true
+		attempt.Key = key
+	}
+}
+
+type authOutcome struct {
+	key     *internal.APIKey
+	session *internal.Session
+	err     error
+}
+
+type authOutcomeContext struct{}
+
+// WithAuthentication authenticates rqst once, the returned request carries the outcome
+// so Authenticate calls by later middleware and handlers reuse it instead of hashing
+// and looking the key up again. An authenticated key is also set with internal.WithAPIKey
+func WithAuthentication(store *FileStore, rqst *http.Request) (*http.Request, *internal.APIKey, error) {
+	key, session, err := Authenticate(store, rqst)
+
+	ctx := context.WithValue(rqst.Context(), authOutcomeContext{}, &authOutcome{key, session, err})
+	if key != nil {
+		ctx = internal.WithAPIKey(ctx, key)
+	}
+
+	return rqst.WithContext(ctx), key, err
+}
+
+// Authenticate resolves the caller from X-API-Key, falling back to the session cookie.
+// Cookies are sent by the browser on their own so cookie authenticated writes must also
+// echo the session's CSRF token, otherwise ErrCSRF is returned
+func Authenticate(store *FileStore, rqst *http.Request) (*internal.APIKey, *internal.Session, error) {
+	if outcome, ok := rqst.Context().Value(authOutcomeContext{}).(*authOutcome); ok {
+		if rqst.Header.Get("X-API-Key") != "" && outcome.err == nil {
+			recordAuth(rqst, outcome.key)
+		}
+		return outcome.key, outcome.session, outcome.err
+	}
+
+	if presented := rqst.Header.Get("X-API-Key"); presented != "" {
+		key, err := store.Database.Authenticate(presented)
+		if err == nil {
+			recordAuth(rqst, key)
+		}
+		return key, nil, err
+	}
+
+	cookie, err := rqst.Cookie(SessionCookie)
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	session, err := store.Database.LookupSession(cookie.Value)
+	if err != nil || session == nil {
+		return nil, nil, err
+	}
+
+	if !safeMethod(rqst.Method) && !validCSRF(rqst, session) {
+		return nil, session, ErrCSRF
+	}
+
+	return session.APIKey(), session, nil
+}
 
-	default:
-		werr(rspn, http.StatusBadRequest)
+// validCSRF reports whether rqst echoes the CSRF token of session
+func validCSRF(rqst *http.Request, session *internal.Session) bool {
+	token := rqst.Header.Get(CSRFHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func verifyAuth(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	key, session, err := Authenticate(store, rqst)
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if key == nil {
+		wjson(rspn, http.StatusOK, authResponse{Valid: false})
+		return
 	}
+
+	// valid can decrement hatred counter :3
+	ip, _, err := net.SplitHostPort(rqst.RemoteAddr)
+	if err != nil {
+		rspn.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Valid login for key %s from %s\n", key.Name, ip)
+	wjson(rspn, http.StatusOK, sessionResponse(key, session))
 }
 
-func verifyAuth(_ *FileStore, _ string, rspn http.ResponseWriter, rqst *http.Request) {
-	valid := false
+// login exchanges the X-API-Key for a session cookie, so the browser never has to keep
+// the key itself
+func login(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	if rqst.Header.Get("X-API-Key") == "" {
+		wjson(rspn, http.StatusUnauthorized, authResponse{Valid: false})
+		return
+	}
+
+	key, _, err := Authenticate(store, rqst)
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if key == nil {
+		wjson(rspn, http.StatusUnauthorized, authResponse{Valid: false})
+		return
+	}
 
-	if rqst.Header.Get("X-API-Key") == os.Getenv("ADMIN_SECRET") {
-		valid = true
-		// valid can decrement hatred counter :3
-		ip, _, err := net.SplitHostPort(rqst.RemoteAddr)
+	if cookie, err := rqst.Cookie(SessionCookie); err == nil {
+		if err := store.Database.DeleteSession(cookie.Value); err != nil {
+			log.Println(err)
+		}
+	}
+
+	session, token, err := store.Database.CreateSession(key)
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	http.SetCookie(rspn, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/api/",
+		Expires:  session.CreatedAt.Add(internal.SessionLifetime),
+		HttpOnly: true,
+		Secure:   rqst.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	ip, _, _ := net.SplitHostPort(rqst.RemoteAddr)
+	internal.Audit("session.login", "key", key.Name, "client", ip)
+	wjson(rspn, http.StatusOK, sessionResponse(key, session))
+}
+
+// logout ends the session of the cookie, which is a cookie authenticated write like any
+// other so a forged request without the CSRF token can't end it
+func logout(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	if cookie, err := rqst.Cookie(SessionCookie); err == nil {
+		session, err := store.Database.LookupSession(cookie.Value)
 		if err != nil {
-			rspn.WriteHeader(http.StatusInternalServerError)
+			werr(rspn, http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+
+		if session != nil && !validCSRF(rqst, session) {
+			http.Error(rspn, ErrCSRF.Error(), http.StatusForbidden)
+			return
+		}
+
+		if err := store.Database.DeleteSession(cookie.Value); err != nil {
+			werr(rspn, http.StatusInternalServerError)
+			log.Println(err)
 			return
 		}
+	}
+
+	http.SetCookie(rspn, &http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/api/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   rqst.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	wstd(rspn, http.StatusNoContent)
+}
 
-		log.Printf("Valid login from %s\n", ip)
+func sessionResponse(key *internal.APIKey, session *internal.Session) authResponse {
+	rspn := authResponse{Valid: true, Name: key.Name, Scopes: key.Scopes}
+	if session != nil {
+		rspn.CSRFToken = session.CSRFToken
+		rspn.ExpiresAt = session.ExpiresAt
 	}
 
-	rslt := fmt.Sprintf(`{"valid": %v}`, valid)
-	rspn.Header().Set("Content-Type", "application/json")
-	rspn.WriteHeader(http.StatusOK)
-	rspn.Write([]byte(rslt))
+	return rspn
 }
@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+func TestSessionLogin(t *testing.T) {
+	store := internal.NewTestStore(t)
+	t.Setenv("ADMIN_SECRET", "")
+
+	_, secret, err := store.Database.CreateAPIKey("uploader", []string{internal.ScopePhotosWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rqst := httptest.NewRequest(http.MethodPost, "/api/v1/auth", nil)
+	rqst.Header.Set("X-API-Key", "gdn_wrong_key")
+	rspn := httptest.NewRecorder()
+	login(store, rspn, rqst)
+	if rspn.Code != http.StatusUnauthorized || len(rspn.Result().Cookies()) != 0 {
+		t.Fatalf("Expected a wrong key to be refused without a cookie, but got %d %v", rspn.Code, rspn.Result().Cookies())
+	}
+
+	rqst = httptest.NewRequest(http.MethodPost, "/api/v1/auth", nil)
+	rqst.Header.Set("X-API-Key", secret)
+	rspn = httptest.NewRecorder()
+	login(store, rspn, rqst)
+
+	cookies := rspn.Result().Cookies()
+	if rspn.Code != http.StatusOK || len(cookies) != 1 {
+		t.Fatalf("Expected a session cookie, but got %d %v", rspn.Code, cookies)
+	}
+
+	cookie := cookies[0]
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.Value == secret {
+		t.Errorf("Expected an HttpOnly SameSite cookie not holding the key, but got %v", cookie)
+	}
+
+	var body authResponse
+	if err := json.NewDecoder(rspn.Body).Decode(&body); err != nil || !body.Valid || body.CSRFToken == "" {
+		t.Fatalf("Expected a valid response with a CSRF token, but got %+v %v", body, err)
+	}
+
+	rqst = httptest.NewRequest(http.MethodGet, "/api/v1/photos", nil)
+	rqst.AddCookie(cookie)
+	if key, _, err := Authenticate(store, rqst); err != nil || key == nil || key.Name != "uploader" {
+		t.Errorf("Expected the cookie to authenticate reads, but got %v %v", key, err)
+	}
+
+	rqst = httptest.NewRequest(http.MethodPatch, "/api/v1/photos/x", nil)
+	rqst.AddCookie(cookie)
+	if _, _, err := Authenticate(store, rqst); !errors.Is(err, ErrCSRF) {
+		t.Errorf("Expected a cookie write without a CSRF token to fail, but got %v", err)
+	}
+
+	rqst.Header.Set(CSRFHeader, body.CSRFToken)
+	if key, _, err := Authenticate(store, rqst); err != nil || !key.HasScope(internal.ScopePhotosWrite) {
+		t.Errorf("Expected a cookie write with the CSRF token to pass, but got %v %v", key, err)
+	}
+
+	// logging out is a cookie write too
+	rqst = httptest.NewRequest(http.MethodDelete, "/api/v1/auth", nil)
+	rqst.AddCookie(cookie)
+	rspn = httptest.NewRecorder()
+	logout(store, rspn, rqst)
+	if rspn.Code != http.StatusForbidden {
+		t.Fatalf("Expected a logout without the CSRF token to be refused, but got %d", rspn.Code)
+	}
+
+	if session, err := store.Database.LookupSession(cookie.Value); err != nil || session == nil {
+		t.Fatalf("Expected the refused logout to keep the session, but got %v %v", session, err)
+	}
+
+	rqst.Header.Set(CSRFHeader, body.CSRFToken)
+	rspn = httptest.NewRecorder()
+	logout(store, rspn, rqst)
+	if rspn.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, but got %d", http.StatusNoContent, rspn.Code)
+	}
+
+	rqst = httptest.NewRequest(http.MethodGet, "/api/v1/auth", nil)
+	rqst.AddCookie(cookie)
+	if key, _, err := Authenticate(store, rqst); err != nil || key != nil {
+		t.Errorf("Expected the session to end on logout, but got %v %v", key, err)
+	}
+}
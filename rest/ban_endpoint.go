@@ -0,0 +1,137 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+// banRequest bans an address or CIDR range, without a duration the ban is permanent.
+// Bans covering everything or the requester are refused unless forced, bans are checked
+// before authentication so they would also lock out whoever has to lift them
+type banRequest struct {
+	CIDR     string `json:"cidr"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+	Force    bool   `json:"force"`
+}
+
+func listBans(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	expired := false
+	if rqst.URL.Query().Has("expired") {
+		rslt, err := strconv.ParseBool(rqst.URL.Query().Get("expired"))
+		if err != nil {
+			werr(rspn, http.StatusBadRequest)
+			return
+		}
+		expired = rslt
+	}
+
+	bans, err := store.Database.ListBans(expired)
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	wjson(rspn, http.StatusOK, bans)
+}
+
+func createBan(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(rspn, rqst.Body, 25<<10))
+	if err != nil {
+		werr(rspn, http.StatusBadRequest)
+		return
+	}
+
+	var body banRequest
+	if err := json.Unmarshal(data, &body); err != nil {
+		http.Error(rspn, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	target, err := internal.ParsePrefix(body.CIDR)
+	if err != nil {
+		http.Error(rspn, "Invalid address or CIDR range "+strconv.Quote(body.CIDR), http.StatusBadRequest)
+		return
+	}
+
+	if !body.Force {
+		if target.Bits() == 0 {
+			http.Error(rspn, "Refusing to ban every address, set force to do it anyway", http.StatusConflict)
+			return
+		}
+
+		if addr, err := requesterAddr(rqst); err == nil && target.Contains(addr) {
+			http.Error(rspn, "Refusing to ban your own address "+addr.String()+", set force to do it anyway", http.StatusConflict)
+			return
+		}
+	}
+
+	var expires time.Time
+	if body.Duration != "" {
+		duration, err := time.ParseDuration(body.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(rspn, "Invalid duration "+strconv.Quote(body.Duration), http.StatusBadRequest)
+			return
+		}
+		expires = time.Now().Add(duration)
+	}
+
+	by := internal.APIKeyFrom(rqst.Context()).Name
+	ban, err := store.Database.AddBan(target, body.Reason, internal.BanManual, by, expires)
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	internal.Audit("ban.manual", "id", ban.Id, "cidr", ban.CIDR, "reason", ban.Reason, "by", by)
+	wjson(rspn, http.StatusCreated, ban)
+}
+
+// requesterAddr is the client address the ban check matches against
+func requesterAddr(rqst *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(rqst.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return addr.Unmap().WithZone(""), nil
+}
+
+func deleteBan(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	id, err := strconv.ParseInt(rqst.PathValue("id"), 10, 64)
+	if err != nil {
+		werr(rspn, http.StatusBadRequest)
+		return
+	}
+
+	err = store.Database.DeleteBan(id)
+	if errors.Is(err, internal.ErrBanNotFound) {
+		werr(rspn, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	internal.Audit("ban.lift", "id", id, "by", internal.APIKeyFrom(rqst.Context()).Name)
+	wstd(rspn, http.StatusNoContent)
+}
@@ -0,0 +1,47 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+func TestCreateBanGuards(t *testing.T) {
+	store := internal.NewTestStore(t)
+	admin := &internal.APIKey{Name: internal.RootKeyName, Scopes: []string{internal.ScopeAdmin}}
+
+	create := func(body string) int {
+		rqst := httptest.NewRequest(http.MethodPost, "/api/v1/admin/bans", strings.NewReader(body))
+		rqst.RemoteAddr = "[::ffff:192.0.2.7]:4321"
+		rqst = rqst.WithContext(internal.WithAPIKey(rqst.Context(), admin))
+		rspn := httptest.NewRecorder()
+		createBan(store, rspn, rqst)
+		return rspn.Code
+	}
+
+	tests := []struct {
+		body     string
+		expected int
+	}{
+		{`{"cidr": "0.0.0.0/0"}`, http.StatusConflict},
+		{`{"cidr": "::/0"}`, http.StatusConflict},
+		{`{"cidr": "192.0.2.0/24"}`, http.StatusConflict},
+		{`{"cidr": "192.0.2.7"}`, http.StatusConflict},
+		{`{"cidr": "198.51.100.0/24"}`, http.StatusCreated},
+		{`{"cidr": "192.0.2.0/24", "force": true}`, http.StatusCreated},
+	}
+
+	for _, test := range tests {
+		if got := create(test.body); got != test.expected {
+			t.Errorf("%s: expected %d, but got %d", test.body, test.expected, got)
+		}
+	}
+
+	bans, err := store.Database.ListBans(false)
+	if err != nil || len(bans) != 2 {
+		t.Errorf("Expected only the two allowed bans, but got %v %v", bans, err)
+	}
+}
@@ -0,0 +1,103 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+type keyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// keyResponse is the only time a key's secret is ever shown
+type keyResponse struct {
+	*internal.APIKey
+	Key string `json:"key"`
+}
+
+func listKeys(store *FileStore, rspn http.ResponseWriter, _ *http.Request) {
+	keys, err := store.Database.ListAPIKeys()
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	wjson(rspn, http.StatusOK, keys)
+}
+
+func createKey(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(rspn, rqst.Body, 25<<10))
+	if err != nil {
+		werr(rspn, http.StatusBadRequest)
+		return
+	}
+
+	var body keyRequest
+	if err := json.Unmarshal(data, &body); err != nil {
+		http.Error(rspn, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	key, secret, err := store.Database.CreateAPIKey(body.Name, body.Scopes)
+	if errors.Is(err, internal.ErrAPIKeyInvalid) {
+		http.Error(rspn, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, internal.ErrAPIKeyExists) {
+		http.Error(rspn, "An api key named "+body.Name+" already exists", http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	internal.Audit("key.create", "key", key.Name, "scopes", strings.Join(key.Scopes, ","), "by", internal.APIKeyFrom(rqst.Context()).Name)
+	wjson(rspn, http.StatusCreated, keyResponse{APIKey: key, Key: secret})
+}
+
+func rotateKey(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	key, secret, err := store.Database.RotateAPIKey(rqst.PathValue("name"))
+	if errors.Is(err, internal.ErrAPIKeyNotFound) {
+		werr(rspn, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	internal.Audit("key.rotate", "key", key.Name, "by", internal.APIKeyFrom(rqst.Context()).Name)
+	wjson(rspn, http.StatusOK, keyResponse{APIKey: key, Key: secret})
+}
+
+func revokeKey(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	name := rqst.PathValue("name")
+	err := store.Database.RevokeAPIKey(name)
+	if errors.Is(err, internal.ErrAPIKeyNotFound) {
+		werr(rspn, http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	internal.Audit("key.revoke", "key", name, "by", internal.APIKeyFrom(rqst.Context()).Name)
+	wstd(rspn, http.StatusNoContent)
+}
@@ -0,0 +1,22 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+func listLockouts(store *FileStore, rspn http.ResponseWriter, _ *http.Request) {
+	wjson(rspn, http.StatusOK, store.Lockouts.List())
+}
+
+func clearLockout(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	client := rqst.PathValue("client")
+	if !store.Lockouts.Clear(client) {
+		werr(rspn, http.StatusNotFound)
+		return
+	}
+
+	internal.Audit("lockout.clear", "client", client, "by", internal.APIKeyFrom(rqst.Context()).Name)
+	wstd(rspn, http.StatusNoContent)
+}
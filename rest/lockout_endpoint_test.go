@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+func TestClearLockout(t *testing.T) {
+	store := internal.NewTestStore(t)
+	store.Lockouts.Fail("2001:db8::/64")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /api/v1/admin/lockouts/{client}", func(rspn http.ResponseWriter, rqst *http.Request) {
+		rqst = rqst.WithContext(internal.WithAPIKey(rqst.Context(), &internal.APIKey{Name: "admin"}))
+		clearLockout(store, rspn, rqst)
+	})
+
+	request := func(path string) int {
+		rspn := httptest.NewRecorder()
+		mux.ServeHTTP(rspn, httptest.NewRequest(http.MethodDelete, path, nil))
+		return rspn.Code
+	}
+
+	// aggregated clients hold a slash, written literally it splits the path
+	if code := request("/api/v1/admin/lockouts/2001:db8::/64"); code != http.StatusNotFound {
+		t.Errorf("Expected an unencoded prefix not to match, but got %d", code)
+	}
+
+	if code := request("/api/v1/admin/lockouts/2001:db8::%2F64"); code != http.StatusNoContent {
+		t.Fatalf("Expected the percent-encoded prefix to be cleared, but got %d", code)
+	}
+
+	if list := store.Lockouts.List(); len(list) != 0 {
+		t.Errorf("Expected the lockout to be gone, but got %v", list)
+	}
+}
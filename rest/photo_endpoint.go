@@ -2,39 +2,22 @@ package rest
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"log"
 	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
 
+	"github.com/Y2Kwastaken/gdn/internal"
 	"github.com/google/uuid"
-	"github.com/minio/minio-go/v7"
 )
 
-func PhotoEndpoints(store *FileStore, urlPart string, rspn http.ResponseWriter, rqst *http.Request) {
-	switch rqst.Method {
-	case http.MethodPut:
-		putPhoto(store, urlPart, rspn, rqst)
-	case http.MethodGet:
-		getPhoto(store, urlPart, rspn, rqst)
-	case http.MethodDelete:
-		delPhoto(store, urlPart, rspn, rqst)
-	default:
-		werr(rspn, http.StatusBadRequest)
-	}
-
-}
-
-func delPhoto(store *FileStore, urlPart string, rspn http.ResponseWriter, rqst *http.Request) {
-	if rqst.Header.Get("X-API-Key") != os.Getenv("ADMIN_SECRET") {
-		werr(rspn, http.StatusUnauthorized)
-		return
-	}
-
+func delPhoto(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	urlPart := rqst.PathValue("id")
 	uuid, err := uuid.Parse(urlPart)
 	if err != nil {
 		http.Error(rspn, "Unable to parse photo uuid from"+urlPart+" wrong endpoint?", http.StatusBadRequest)
@@ -42,13 +25,18 @@ func delPhoto(store *FileStore, urlPart string, rspn http.ResponseWriter, rqst *
 	}
 	uuidstr := uuid.String()
 
-	err = store.Client.RemoveObject(rqst.Context(), "images", uuidstr, minio.RemoveObjectOptions{})
+	err = store.DeleteObject(rqst.Context(), store.Bucket, uuidstr)
 	if err != nil {
 		werr(rspn, http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
 
+	err = store.DeleteDerivatives(rqst.Context(), uuid)
+	if err != nil {
+		log.Println("Couldn't remove derivatives of uuid", uuid, err)
+	}
+
 	err = store.Database.DeleteImage(uuid)
 	if err != nil {
 		werr(rspn, http.StatusInternalServerError)
@@ -62,54 +50,180 @@ func delPhoto(store *FileStore, urlPart string, rspn http.ResponseWriter, rqst *
 			}
 		}
 
-		log.Println("[SEVERE] unable to delete the uuid ", uuid, " from the database Manual removal IS REQUIRED")
+		log.Println("[SEVERE] unable to delete the uuid ", uuid, " from the database Manual removal IS REQUIRED, run reconcile -repair")
 		return
 	}
 
 	wstd(rspn, http.StatusOK)
 }
 
-func getPhoto(store *FileStore, urlPart string, rspn http.ResponseWriter, rqst *http.Request) {
-	if urlPart == "" {
-		getPhotoIds(store, urlPart, rspn, rqst)
+func patchPhoto(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	urlPart := rqst.PathValue("id")
+	uuid, err := uuid.Parse(urlPart)
+	if err != nil {
+		http.Error(rspn, "Unable to parse photo uuid from "+urlPart+" wrong endpoint?", http.StatusBadRequest)
 		return
 	}
-	uuid, err := uuid.Parse(urlPart)
+
+	mtype, _, err := mime.ParseMediaType(rqst.Header.Get("Content-Type"))
+	if err != nil || (mtype != "application/merge-patch+json" && mtype != "application/json") {
+		werr(rspn, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(rspn, rqst.Body, store.Uploads.MaxMetadata)) // same cap as upload metadata
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		wtoolarge(rspn, "Metadata", store.Uploads.MaxMetadata)
+		return
+	}
+
 	if err != nil {
-		http.Error(rspn, "Unlabe to parse photo uuid from "+urlPart+" wrong endpoint?", http.StatusBadRequest)
+		http.Error(rspn, "Data read failed", http.StatusBadRequest)
+		return
+	}
+
+	var patch MetadataPatch
+	if err := json.Unmarshal(data, &patch); err != nil {
+		http.Error(rspn, "Invalid merge patch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := store.Database.UpdateImageMeta(uuid, &patch)
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	if meta == nil {
+		http.Error(rspn, "No image with uuid "+uuid.String(), http.StatusNotFound)
+		return
+	}
+
+	log.Println("Updated Image", uuid, meta.ImageName)
+	rspn.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rspn).Encode(meta); err != nil {
+		log.Println(err)
+	}
+}
+
+// getPhoto serves metadata to JSON clients and the image to everything else
+func getPhoto(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	rspn.Header().Add("Vary", "Accept")
+	if acceptsJSON(rqst) {
+		getPhotoMeta(store, rspn, rqst)
+	} else {
+		getPhotoFile(store, rspn, rqst)
+	}
+}
+
+func getPhotoMeta(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	meta := lookupPhoto(store, rspn, rqst)
+	if meta == nil {
 		return
 	}
+
+	rspn.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rspn).Encode(meta); err != nil {
+		log.Println(err)
+	}
+}
+
+// lookupPhoto resolves the {id} path value, answering the request itself and returning
+// nil when there is no such photo
+func lookupPhoto(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) *ImageMeta {
+	urlPart := rqst.PathValue("id")
+	uuid, err := uuid.Parse(urlPart)
+	if err != nil {
+		http.Error(rspn, "Unable to parse photo uuid from "+urlPart+" wrong endpoint?", http.StatusBadRequest)
+		return nil
+	}
 	uuidstr := uuid.String()
 
 	meta, err := store.Database.QueryImage(uuid)
 	if err != nil {
 		werr(rspn, http.StatusInternalServerError)
 		log.Println(err)
-		return
+		return nil
 	}
 
 	if meta == nil {
-		http.Error(rspn, "No image with uuid "+uuidstr, http.StatusBadRequest)
+		http.Error(rspn, "No image with uuid "+uuidstr, http.StatusNotFound)
 		log.Println("No image with uuid", uuidstr)
+		return nil
+	}
+
+	return meta
+}
+
+func getPhotoFile(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	meta := lookupPhoto(store, rspn, rqst)
+	if meta == nil {
+		return
+	}
+	uuid := meta.Id
+	uuidstr := uuid.String()
+
+	bucket, key, imageType, name := store.Bucket, uuidstr, meta.ImageType, meta.ImageName
+	if size := rqst.URL.Query().Get("size"); size != "" && size != "original" {
+		derivative, err := store.Database.QueryDerivative(uuid, size)
+		if err != nil {
+			werr(rspn, http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+
+		if derivative == nil {
+			http.Error(rspn, "No "+size+" derivative for uuid "+uuidstr, http.StatusNotFound)
+			return
+		}
+
+		bucket, key, imageType = internal.DerivativeBucket, internal.DerivativeKey(uuid, size), derivative.ImageType
+		name = strings.TrimSuffix(name, path.Ext(name)) + "_" + size + "." + derivative.Format
+	}
+
+	object, info, err := store.GetObject(rqst.Context(), bucket, key)
+	if errors.Is(err, internal.ErrBlobNotFound) {
+		http.Error(rspn, "No image with uuid "+uuidstr, http.StatusNotFound)
+		log.Println("Image metadata without object for uuid", uuidstr)
 		return
 	}
 
-	path := meta.ImageName
-	err = store.Client.FGetObject(rqst.Context(), "images", uuidstr, path, minio.GetObjectOptions{})
 	if err != nil {
 		werr(rspn, http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
-	defer os.Remove(path)
-	defer log.Println("disposed " + path)
-	log.Println("serving ", path)
+	defer object.Close()
+
+	header := rspn.Header()
+	header.Set("Content-Type", imageType)
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	header.Set("Content-Disposition", disposition)
+	if info.ETag != "" {
+		header.Set("ETag", strconv.Quote(strings.Trim(info.ETag, `"`)))
+	}
 
-	rspn.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, path))
-	http.ServeFile(rspn, rqst, path)
+	// ServeContent takes care of Range, conditional requests, Content-Length and Last-Modified
+	http.ServeContent(rspn, rqst, "", info.LastModified, object)
 }
 
-func getPhotoIds(store *FileStore, _ string, rspn http.ResponseWriter, rqst *http.Request) {
+func acceptsJSON(rqst *http.Request) bool {
+	for accept := range strings.SplitSeq(rqst.Header.Get("Accept"), ",") {
+		mtype, _, err := mime.ParseMediaType(accept)
+		if err == nil && (mtype == "application/json" || strings.HasSuffix(mtype, "+json")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getPhotoIds(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
 	query := rqst.URL.Query()
 	limit := -1
 	offset := 0
@@ -166,9 +280,15 @@ func getPhotoIds(store *FileStore, _ string, rspn http.ResponseWriter, rqst *htt
 		entries = rslt
 	}
 
+	filter, err := parseTagFilter(query)
+	if err != nil {
+		http.Error(rspn, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var uuids []uuid.UUID
 	if limit != -1 {
-		rslt, err := store.Database.QueryIds(limit, offset)
+		rslt, err := store.Database.QueryIdsFiltered(filter, limit, offset)
 		if err != nil {
 			werr(rspn, http.StatusInternalServerError)
 			log.Println(err)
@@ -178,7 +298,7 @@ func getPhotoIds(store *FileStore, _ string, rspn http.ResponseWriter, rqst *htt
 	}
 
 	if entries != -1 {
-		rslt, err := store.Database.CountEntries()
+		rslt, err := store.Database.CountEntriesFiltered(filter)
 		if err != nil {
 			werr(rspn, http.StatusInternalServerError)
 			log.Println(err)
@@ -186,7 +306,7 @@ func getPhotoIds(store *FileStore, _ string, rspn http.ResponseWriter, rqst *htt
 		}
 		entries = rslt
 
-		if offset >= entries {
+		if offset > 0 && offset >= entries {
 			http.Error(rspn, "offset greater than or equal to total entry length", http.StatusBadRequest)
 			return
 		}
@@ -202,12 +322,74 @@ func getPhotoIds(store *FileStore, _ string, rspn http.ResponseWriter, rqst *htt
 	}
 }
 
-func putPhoto(store *FileStore, _ string, rspn http.ResponseWriter, rqst *http.Request) {
-	if rqst.Header.Get("X-API-Key") != os.Getenv("ADMIN_SECRET") {
-		werr(rspn, http.StatusUnauthorized)
+func searchPhotos(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
+	query := rqst.URL.Query()
+	text := query.Get("q")
+	if len(text) > 256 {
+		http.Error(rspn, "search query must not exceed 256 characters", http.StatusBadRequest)
 		return
 	}
 
+	limit := 20
+	offset := 0
+	if query.Has("limit") {
+		rslt, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || rslt < 1 || rslt > 20 {
+			werr(rspn, http.StatusBadRequest)
+			return
+		}
+		limit = rslt
+	}
+
+	if query.Has("offset") {
+		rslt, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || rslt < 0 {
+			werr(rspn, http.StatusBadRequest)
+			return
+		}
+		offset = rslt
+	}
+
+	results, err := store.Database.SearchImages(text, limit, offset)
+	if errors.Is(err, internal.ErrEmptySearch) {
+		http.Error(rspn, "search query q is required", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		werr(rspn, http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	rspn.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rspn).Encode(SearchResponse{Results: results}); err != nil {
+		log.Println(err)
+	}
+}
+
+// parseTagFilter reads ?tag=a&tag=b&match=all|any&exclude=c, returning nil when no
+// tag parameters are present
+func parseTagFilter(query url.Values) (*TagFilter, error) {
+	tags := query["tag"]
+	exclude := query["exclude"]
+	if len(tags)+len(exclude) > 20 {
+		return nil, errors.New("at most 20 tag and exclude parameters are allowed")
+	}
+
+	match := query.Get("match")
+	if match != "" && match != "all" && match != "any" {
+		return nil, errors.New("match must be all or any")
+	}
+
+	if len(tags) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	return &TagFilter{Tags: tags, MatchAll: match != "any", Exclude: exclude}, nil
+}
+
+func putPhoto(store *FileStore, rspn http.ResponseWriter, rqst *http.Request) {
 	ctype := rqst.Header.Get("Content-Type")
 	mtype, _, err := mime.ParseMediaType(ctype)
 	if err != nil {
@@ -238,11 +420,19 @@ func putPhoto(store *FileStore, _ string, rspn http.ResponseWriter, rqst *http.R
 		return
 	}
 
-	limreader := io.LimitReader(part, 25<<10) // json size must not exceed 25kbs
-	data, err := io.ReadAll(limreader)
+	// the parts are read through MaxBytesReader, a LimitReader would silently cut them short
+	data, err := io.ReadAll(http.MaxBytesReader(rspn, part, store.Uploads.MaxMetadata))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		wtoolarge(rspn, "Metadata", store.Uploads.MaxMetadata)
+		log.Println(rqst.RemoteAddr, "attempted to send metadata over", sizeText(store.Uploads.MaxMetadata))
+		return
+	}
+
 	if err != nil {
-		http.Error(rspn, "Data read failed check to ensure your json file doesn't exceed 25kbs", http.StatusBadRequest)
-		log.Println(rqst.RemoteAddr, " attempted to send json file of size ", len(data)/(1024), "kbs")
+		http.Error(rspn, "Unable to Process form parts", http.StatusBadRequest)
+		log.Println(err)
+		return
 	}
 
 	var metadata Metadata
@@ -272,8 +462,13 @@ func putPhoto(store *FileStore, _ string, rspn http.ResponseWriter, rqst *http.R
 
 	metadata.ImageType = imageType
 
-	limreader = io.LimitReader(part, 50<<20)
-	err = store.UploadFS(rqst.Context(), "images", &metadata, limreader)
+	err = store.UploadFS(rqst.Context(), store.Bucket, &metadata, http.MaxBytesReader(rspn, part, store.Uploads.MaxImage))
+	if errors.As(err, &tooLarge) {
+		wtoolarge(rspn, "Image", store.Uploads.MaxImage)
+		log.Println(rqst.RemoteAddr, "attempted to upload an image over", sizeText(store.Uploads.MaxImage))
+		return
+	}
+
 	if err != nil {
 		werr(rspn, http.StatusInternalServerError)
 		log.Println(err)
@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+func TestGetPhotoRange(t *testing.T) {
+	store := internal.NewTestStore(t)
+	metadata := &Metadata{Title: "cat.png", ImageType: "image/png"}
+	if err := store.UploadFS(store.Context, "images", metadata, strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := store.Database.QueryIds(1, 0)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("Expected one uploaded id, but got %v %v", ids, err)
+	}
+
+	rqst := httptest.NewRequest(http.MethodGet, "/api/v1/photos/"+ids[0].String()+"/file", nil)
+	rqst.SetPathValue("id", ids[0].String())
+	rqst.Header.Set("Range", "bytes=2-5")
+	rspn := httptest.NewRecorder()
+	getPhotoFile(store, rspn, rqst)
+
+	if rspn.Code != http.StatusPartialContent {
+		t.Fatalf("Expected status %d, but got %d", http.StatusPartialContent, rspn.Code)
+	}
+
+	if got := rspn.Body.String(); got != "2345" {
+		t.Errorf("Expected body 2345, but got %s", got)
+	}
+
+	if got := rspn.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Expected Content-Type image/png, but got %s", got)
+	}
+
+	if rspn.Header().Get("ETag") == "" || rspn.Header().Get("Last-Modified") == "" {
+		t.Errorf("Expected ETag and Last-Modified headers, but got %v", rspn.Header())
+	}
+}
+
+func TestGetPhotoMeta(t *testing.T) {
+	store := internal.NewTestStore(t)
+	metadata := &Metadata{Title: "cat.png", ImageType: "image/png", Tags: []string{"gray"}}
+	if err := store.UploadFS(store.Context, "images", metadata, strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := store.Database.QueryIds(1, 0)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("Expected one uploaded id, but got %v %v", ids, err)
+	}
+
+	for name, handler := range map[string]Handler{"meta": getPhotoMeta, "negotiated": getPhoto} {
+		rqst := httptest.NewRequest(http.MethodGet, "/api/v1/photos/"+ids[0].String(), nil)
+		rqst.SetPathValue("id", ids[0].String())
+		rqst.Header.Set("Accept", "application/json")
+		rspn := httptest.NewRecorder()
+		handler(store, rspn, rqst)
+
+		var meta ImageMeta
+		if err := json.NewDecoder(rspn.Body).Decode(&meta); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if meta.Id != ids[0] || meta.ImageName != "cat.png" || meta.Size != 10 || meta.CreatedAt.IsZero() {
+			t.Errorf("%s: expected metadata of the upload, but got %+v", name, meta)
+		}
+	}
+}
+
+func TestPutPhotoLimits(t *testing.T) {
+	store := internal.NewTestStore(t)
+	store.Bucket = "images"
+	store.Uploads = internal.UploadLimits{MaxImage: 16, MaxMetadata: 64}
+
+	upload := func(metadata string, image string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		form := multipart.NewWriter(body)
+		part, _ := form.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+		part.Write([]byte(metadata))
+		part, _ = form.CreatePart(textproto.MIMEHeader{"Content-Type": {"image/png"}})
+		part.Write([]byte(image))
+		form.Close()
+
+		rqst := httptest.NewRequest(http.MethodPut, "/api/v1/photos", body)
+		rqst.Header.Set("Content-Type", form.FormDataContentType())
+		rspn := httptest.NewRecorder()
+		putPhoto(store, rspn, rqst)
+		return rspn
+	}
+
+	if rspn := upload(`{"title": "cat"}`, strings.Repeat("x", 16)); rspn.Code != http.StatusOK {
+		t.Fatalf("Expected an image at the limit to be stored, but got %d %s", rspn.Code, rspn.Body)
+	}
+
+	// oversized parts are refused instead of being cut short
+	rspn := upload(`{"title": "cat"}`, strings.Repeat("x", 17))
+	if rspn.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rspn.Body.String(), "16B") {
+		t.Errorf("Expected an oversized image to be refused naming the limit, but got %d %s", rspn.Code, rspn.Body)
+	}
+
+	rspn = upload(`{"title": "`+strings.Repeat("c", 64)+`"}`, "x")
+	if rspn.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rspn.Body.String(), "64B") {
+		t.Errorf("Expected oversized metadata to be refused naming the limit, but got %d %s", rspn.Code, rspn.Body)
+	}
+
+	if ids, err := store.Database.QueryIds(10, 0); err != nil || len(ids) != 1 {
+		t.Errorf("Expected only the image within the limits to be stored, but got %v %v", ids, err)
+	}
+}
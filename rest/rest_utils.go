@@ -1,6 +1,9 @@
 package rest
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/Y2Kwastaken/gdn/internal"
@@ -8,7 +11,11 @@ import (
 
 type FileStore = internal.FileStore
 type IdResponse = internal.IdResponse
+type ImageMeta = internal.ImageMeta
 type Metadata = internal.Metadata
+type TagFilter = internal.TagFilter
+type MetadataPatch = internal.MetadataPatch
+type SearchResponse = internal.SearchResponse
 
 func wstd(rspn http.ResponseWriter, code int) {
 	rspn.WriteHeader(code)
@@ -17,3 +24,30 @@ func wstd(rspn http.ResponseWriter, code int) {
 func werr(rspn http.ResponseWriter, code int) {
 	http.Error(rspn, http.StatusText(code), code)
 }
+
+// wtoolarge answers 413 naming the configured limit what exceeded
+func wtoolarge(rspn http.ResponseWriter, what string, limit int64) {
+	http.Error(rspn, fmt.Sprintf("%s must not exceed %s", what, sizeText(limit)), http.StatusRequestEntityTooLarge)
+}
+
+// sizeText formats a byte count in the largest unit dividing it evenly, e.g. 25KB
+func sizeText(size int64) string {
+	for _, unit := range []struct {
+		size   int64
+		suffix string
+	}{{1 << 30, "GB"}, {1 << 20, "MB"}, {1 << 10, "KB"}} {
+		if size >= unit.size && size%unit.size == 0 {
+			return fmt.Sprintf("%d%s", size/unit.size, unit.suffix)
+		}
+	}
+
+	return fmt.Sprintf("%dB", size)
+}
+
+func wjson(rspn http.ResponseWriter, code int, body any) {
+	rspn.Header().Set("Content-Type", "application/json")
+	rspn.WriteHeader(code)
+	if err := json.NewEncoder(rspn).Encode(body); err != nil {
+		log.Println(err)
+	}
+}
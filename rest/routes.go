@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/Y2Kwastaken/gdn/internal"
+)
+
+type Handler func(*FileStore, http.ResponseWriter, *http.Request)
+
+// Route binds a handler to a method and a net/http pattern, wildcards such as {id}
+// are read with Request.PathValue. Routes with a Scope require an API key or session granting it
+type Route struct {
+	Method  string
+	Pattern string
+	Scope   string
+	Handler Handler
+}
+
+func Routes() []Route {
+	return []Route{
+		{http.MethodGet, "/api/v1/photos", "", getPhotoIds},
+		{http.MethodPut, "/api/v1/photos", internal.ScopePhotosWrite, putPhoto},
+		{http.MethodGet, "/api/v1/photos/search", "", searchPhotos},
+		{http.MethodGet, "/api/v1/photos/{id}", "", getPhoto},
+		{http.MethodPatch, "/api/v1/photos/{id}", internal.ScopePhotosWrite, patchPhoto},
+		{http.MethodDelete, "/api/v1/photos/{id}", internal.ScopePhotosDelete, delPhoto},
+		{http.MethodGet, "/api/v1/photos/{id}/meta", "", getPhotoMeta},
+		{http.MethodGet, "/api/v1/photos/{id}/file", "", getPhotoFile},
+
+		{http.MethodGet, "/api/v1/auth", "", verifyAuth},
+		{http.MethodPost, "/api/v1/auth", "", login},
+		{http.MethodDelete, "/api/v1/auth", "", logout},
+
+		{http.MethodGet, "/api/v1/admin/reconcile", internal.ScopeAdmin, reconcile},
+		{http.MethodPost, "/api/v1/admin/reconcile", internal.ScopeAdmin, reconcile},
+
+		{http.MethodGet, "/api/v1/admin/keys", internal.ScopeAdmin, listKeys},
+		{http.MethodPost, "/api/v1/admin/keys", internal.ScopeAdmin, createKey},
+		{http.MethodPost, "/api/v1/admin/keys/{name}/rotate", internal.ScopeAdmin, rotateKey},
+		{http.MethodDelete, "/api/v1/admin/keys/{name}", internal.ScopeAdmin, revokeKey},
+
+		{http.MethodGet, "/api/v1/admin/lockouts", internal.ScopeAdmin, listLockouts},
+		{http.MethodDelete, "/api/v1/admin/lockouts/{client}", internal.ScopeAdmin, clearLockout},
+
+		{http.MethodGet, "/api/v1/admin/bans", internal.ScopeAdmin, listBans},
+		{http.MethodPost, "/api/v1/admin/bans", internal.ScopeAdmin, createBan},
+		{http.MethodDelete, "/api/v1/admin/bans/{id}", internal.ScopeAdmin, deleteBan},
+	}
+}